@@ -0,0 +1,378 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultQueueSize is the queue size used if AsyncOptions.Size is 0.
+const DefaultQueueSize = 1024
+
+// OverflowPolicy defines what an asynchronous queue does with a line when
+// the queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the logging goroutine until the line fits in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest drops the line being logged.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest queued line to make room for the line being logged.
+	OverflowDropOldest
+	// OverflowDropVerbose drops the line being logged if its level is above
+	// AsyncOptions.KeepLevel and blocks otherwise.
+	OverflowDropVerbose
+)
+
+// AsyncOptions defines options of an asynchronous queue.
+type AsyncOptions struct {
+	// Size is the maximum number of queued lines.
+	// If 0, DefaultQueueSize is used.
+	Size int
+	// Policy is the policy applied to lines logged while the queue is full.
+	Policy OverflowPolicy
+	// KeepLevel is the least severe level not dropped by OverflowDropVerbose.
+	// If LevelNone, LevelWarning is used.
+	KeepLevel LogLevel
+}
+
+// queueitem is an item in a queue.
+type queueitem struct {
+	// level is the logging level of the queued line.
+	level LogLevel
+	// fields are the line fields, used by Logger queues.
+	fields *Fields
+	// names are the output names, used by Logger queues.
+	names []string
+	// buf is the formatted line, used by output queues.
+	buf *linebuf
+}
+
+// queue is a bounded FIFO queue of lines processed by a single goroutine.
+type queue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []queueitem
+	head    int
+	count   int
+	busy    bool
+	closed  bool
+	dropped uint64
+	waiters []chan struct{}
+	done    chan struct{}
+
+	policy OverflowPolicy
+	keep   LogLevel
+	handle func(*queueitem)
+}
+
+// newqueue returns a new queue that processes items with handle and starts
+// its goroutine.
+func newqueue(opts *AsyncOptions, handle func(*queueitem)) *queue {
+	if opts == nil {
+		opts = &AsyncOptions{}
+	}
+	size := opts.Size
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	keep := opts.KeepLevel
+	if keep == LevelNone {
+		keep = LevelWarning
+	}
+	q := &queue{
+		items:  make([]queueitem, size),
+		done:   make(chan struct{}),
+		policy: opts.Policy,
+		keep:   keep,
+		handle: handle,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// push appends an item to the queue. Must be called under lock.
+func (q *queue) push(item queueitem) {
+	q.items[(q.head+q.count)%len(q.items)] = item
+	q.count++
+}
+
+// pop removes and returns the first item in queue. Must be called under lock.
+func (q *queue) pop() queueitem {
+	item := q.items[q.head]
+	q.items[q.head] = queueitem{}
+	q.head = (q.head + 1) % len(q.items)
+	q.count--
+	return item
+}
+
+// put queues an item applying the overflow policy if the queue is full.
+// It returns false if the queue is closed and item was not handled.
+func (q *queue) put(item queueitem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && q.count == len(q.items) {
+		switch q.policy {
+		case OverflowDropNewest:
+			q.dropped++
+			return true
+		case OverflowDropOldest:
+			q.pop()
+			q.dropped++
+		case OverflowDropVerbose:
+			if item.level > q.keep {
+				q.dropped++
+				return true
+			}
+			q.cond.Wait()
+		default:
+			q.cond.Wait()
+		}
+	}
+	if q.closed {
+		return false
+	}
+	q.push(item)
+	q.cond.Broadcast()
+	return true
+}
+
+// run processes queued items until the queue is closed and drained.
+func (q *queue) run() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		for q.count == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.count == 0 {
+			q.notify()
+			close(q.done)
+			return
+		}
+		item := q.pop()
+		q.busy = true
+		q.cond.Broadcast()
+		q.mu.Unlock()
+		q.handle(&item)
+		q.mu.Lock()
+		q.busy = false
+		if q.count == 0 {
+			q.notify()
+		}
+	}
+}
+
+// notify releases Flush waiters. Must be called under lock.
+func (q *queue) notify() {
+	for _, ch := range q.waiters {
+		close(ch)
+	}
+	q.waiters = nil
+}
+
+// flush waits until all queued items are processed or ctx is done.
+func (q *queue) flush(ctx context.Context) error {
+	q.mu.Lock()
+	if q.count == 0 && !q.busy {
+		q.mu.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	q.waiters = append(q.waiters, ch)
+	q.mu.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close closes the queue and waits until queued items are processed.
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-q.done
+}
+
+// ndropped returns the number of lines dropped by the queue.
+func (q *queue) ndropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// SetAsync makes the Logger dispatch lines to outputs from a separate
+// goroutine using a queue configured by opts. Lines are queued after the
+// level check and formatted and written by the queue goroutine.
+// If opts is nil the Logger is made synchronous again.
+// Any previous queue is drained before SetAsync returns.
+func (l *Logger) SetAsync(opts *AsyncOptions) {
+	var q *queue
+	if opts != nil {
+		q = newqueue(opts, func(item *queueitem) {
+			l.write(item.fields, item.names...)
+			item.fields.release()
+		})
+	}
+	l.mu.Lock()
+	old := l.queue
+	l.queue = q
+	l.mu.Unlock()
+	if old != nil {
+		old.close()
+		l.mu.Lock()
+		l.dropped += old.ndropped()
+		l.mu.Unlock()
+	}
+}
+
+// AddAsyncOutput registers an output like AddOutput whose lines are written
+// to w from a separate goroutine using a queue configured by opts.
+// Lines are formatted synchronously and only writes are queued.
+// If opts is nil, default options are used.
+// ErrorFunc, if set, is called from the queue goroutine.
+func (l *Logger) AddAsyncOutput(name string, w io.Writer, f Formatter, opts *AsyncOptions) error {
+	if opts == nil {
+		opts = &AsyncOptions{}
+	}
+	return l.AddOutputOptions(name, w, f, OutputOptions{Async: opts})
+}
+
+// Dropped returns the total number of lines dropped by the Logger queue
+// and output queues due to their overflow policy.
+func (l *Logger) Dropped() uint64 {
+	queues := l.outputqueues()
+	l.mu.Lock()
+	n := l.dropped
+	if l.queue != nil {
+		n += l.queue.ndropped()
+	}
+	l.mu.Unlock()
+	for _, q := range queues {
+		n += q.ndropped()
+	}
+	return n
+}
+
+// Flush writes summaries of suppressed duplicate lines and waits until
+// lines queued by the Logger and its outputs are written or ctx is done in
+// which case it returns the ctx error.
+func (l *Logger) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	q := l.queue
+	l.mu.Unlock()
+	if q != nil {
+		if err := q.flush(ctx); err != nil {
+			return err
+		}
+	}
+	l.flushdedup()
+	for _, q := range l.outputqueues() {
+		if err := q.flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close drains and stops the Logger queue and output queues and writes
+// summaries of suppressed duplicate lines.
+// Lines logged after Close are written synchronously. Lines logged to an
+// output while its queue is drained wait until the queue is drained.
+// Close does not close output writers.
+func (l *Logger) Close() error {
+	l.SetAsync(nil)
+	l.flushdedup()
+	var dropped uint64
+	for _, out := range l.outputlist() {
+		if !out.lock() {
+			out.timedout(l.ef)
+			continue
+		}
+		if out.q != nil {
+			out.q.close()
+			dropped += out.q.ndropped()
+			out.q = nil
+		}
+		out.unlock()
+	}
+	l.mu.Lock()
+	l.dropped += dropped
+	l.mu.Unlock()
+	return nil
+}
+
+// flushdedup writes summaries of suppressed duplicate lines of outputs.
+func (l *Logger) flushdedup() {
+	for _, out := range l.outputlist() {
+		out.flushdedup(l.ef)
+	}
+}
+
+// outputqueues returns queues of async outputs.
+func (l *Logger) outputqueues() (queues []*queue) {
+	for _, out := range l.outputlist() {
+		if !out.lock() {
+			out.timedout(l.ef)
+			continue
+		}
+		if out.q != nil {
+			queues = append(queues, out.q)
+		}
+		out.unlock()
+	}
+	return
+}
+
+// exit terminates the program. It is replaced in tests.
+var exit = os.Exit
+
+// fatalflushtimeout is the maximum time Fatal and Panic methods wait for
+// queued lines to be written.
+const fatalflushtimeout = 5 * time.Second
+
+// flushall flushes the Logger queues and output writers that implement a
+// Flush method, with or without a context as in OTLPExporter, or a Sync
+// method before the program exits or panics. Sync errors are ignored as
+// Sync fails on terminals and pipes. It is a no-op on a nil Logger.
+func (l *Logger) flushall() {
+	if l == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), fatalflushtimeout)
+	defer cancel()
+	if err := l.Flush(ctx); err != nil && l.ef != nil {
+		l.ef(err)
+	}
+	for _, out := range l.outputlist() {
+		if !out.lock() {
+			out.timedout(l.ef)
+			continue
+		}
+		var err error
+		switch w := out.w.(type) {
+		case interface{ Flush(context.Context) error }:
+			err = w.Flush(ctx)
+		case interface{ Flush() error }:
+			err = w.Flush()
+		case interface{ Sync() error }:
+			w.Sync()
+		}
+		out.unlock()
+		if err != nil && l.ef != nil {
+			l.ef(err)
+		}
+	}
+}
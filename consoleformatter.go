@@ -0,0 +1,224 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Color is an ANSI terminal escape sequence that sets text color.
+type Color string
+
+const (
+	// ColorNone specifies no color.
+	ColorNone Color = ""
+	// ColorRed is red text.
+	ColorRed Color = "\x1b[31m"
+	// ColorGreen is green text.
+	ColorGreen Color = "\x1b[32m"
+	// ColorYellow is yellow text.
+	ColorYellow Color = "\x1b[33m"
+	// ColorBlue is blue text.
+	ColorBlue Color = "\x1b[34m"
+	// ColorMagenta is magenta text.
+	ColorMagenta Color = "\x1b[35m"
+	// ColorCyan is cyan text.
+	ColorCyan Color = "\x1b[36m"
+	// ColorGray is gray text.
+	ColorGray Color = "\x1b[90m"
+	// ColorBoldRed is bold red text.
+	ColorBoldRed Color = "\x1b[1;31m"
+	// ColorDim is dimmed text.
+	ColorDim Color = "\x1b[2m"
+
+	// colorReset resets text attributes.
+	colorReset = "\x1b[0m"
+)
+
+// DefaultPalette maps predefined logging levels to colors used by
+// ConsoleFormatter if a level is not found in its Palette.
+var DefaultPalette = map[LogLevel]Color{
+	LevelEmergency: ColorBoldRed,
+	LevelAlert:     ColorBoldRed,
+	LevelCritical:  ColorBoldRed,
+	LevelError:     ColorRed,
+	LevelWarning:   ColorYellow,
+	LevelNotice:    ColorCyan,
+	LevelInfo:      ColorGreen,
+	LevelDebug:     ColorBlue,
+	LevelTrace:     ColorGray,
+	LevelPrint:     ColorMagenta,
+}
+
+// consoleLevelWidth is the width of the level column.
+const consoleLevelWidth = 9
+
+// ConsoleFormatter formats Fields as human friendly, optionally colored
+// lines meant for a terminal. The time, level and message are printed in
+// aligned columns followed by custom fields in walk order. The error with
+// its cause chain, caller and stack are printed on indented lines below.
+type ConsoleFormatter struct {
+	// Colors enables colored output.
+	Colors bool
+	// Palette maps logging levels to colors. Levels not found in Palette are
+	// looked up in DefaultPalette and then in registered levels.
+	Palette map[LogLevel]Color
+	// TimeFormat is the timestamp layout.
+	TimeFormat string
+}
+
+// NewConsoleFormatter returns a new ConsoleFormatter for writer w.
+// Colors are enabled if w is a terminal and NO_COLOR environment variable
+// is not set.
+func NewConsoleFormatter(w io.Writer) *ConsoleFormatter {
+	return &ConsoleFormatter{
+		Colors:     colorsupported(w),
+		TimeFormat: "15:04:05.000",
+	}
+}
+
+// colorsupported returns true if w is a terminal and NO_COLOR is not set.
+func colorsupported(w io.Writer) bool {
+	if v, ok := os.LookupEnv("NO_COLOR"); ok && v != "" {
+		return false
+	}
+	return isterminal(w)
+}
+
+// isterminal returns true if w is a character device.
+func isterminal(w io.Writer) bool {
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// color returns the color of level.
+func (cf *ConsoleFormatter) color(level LogLevel) Color {
+	if c, ok := cf.Palette[level]; ok {
+		return c
+	}
+	return levelcolor(level)
+}
+
+// levelcolor returns the color of level from DefaultPalette or the color
+// of a registered level.
+func levelcolor(level LogLevel) Color {
+	if c, ok := DefaultPalette[level]; ok {
+		return c
+	}
+	if info := registeredlevel(level); info != nil {
+		return info.opts.Color
+	}
+	return ColorNone
+}
+
+// paint writes s to sb in color c if colors are enabled.
+func (cf *ConsoleFormatter) paint(sb *strings.Builder, c Color, s string) {
+	if !cf.Colors || c == ColorNone {
+		sb.WriteString(s)
+		return
+	}
+	sb.WriteString(string(c))
+	sb.WriteString(s)
+	sb.WriteString(colorReset)
+}
+
+// writeerror writes ei prefixed with label at indent to sb followed by its
+// frames, cause chain and extra errors, each indented below the error it
+// belongs to.
+func (cf *ConsoleFormatter) writeerror(sb *strings.Builder, ei *ErrorInfo, indent, label string) {
+	const step = "    "
+	sb.WriteString(indent)
+	cf.paint(sb, ColorBoldRed, label)
+	sb.WriteString(strings.ReplaceAll(ei.Message, "\n", "\n"+indent+strings.Repeat(" ", len(label))))
+	if ei.Data != nil {
+		sb.WriteByte(' ')
+		cf.paint(sb, ColorGray, fmt.Sprint(ei.Data))
+	}
+	sb.WriteByte('\n')
+	for _, frame := range ei.Frames {
+		sb.WriteString(indent + step)
+		sb.WriteString(frame.File() + ":" + strconv.Itoa(frame.Line()))
+		if fn := frame.Func(); fn != "" {
+			sb.WriteByte(' ')
+			cf.paint(sb, ColorGray, fn)
+		}
+		sb.WriteByte('\n')
+	}
+	if ei.Cause != nil {
+		cf.writeerror(sb, ei.Cause, indent+step, "caused by: ")
+	}
+	for _, cause := range ei.Causes {
+		cf.writeerror(sb, cause, indent+step, "caused by: ")
+	}
+	for _, extra := range ei.Extras {
+		cf.writeerror(sb, extra, indent+step, "extra: ")
+	}
+}
+
+// Format implements Formatter interface.
+func (cf *ConsoleFormatter) Format(fields *Fields) string {
+	const indent = "    "
+
+	sb := &strings.Builder{}
+	cf.paint(sb, ColorDim, fields.Time().Format(cf.TimeFormat))
+	sb.WriteByte(' ')
+
+	level := fields.LogLevel()
+	name := strings.ToUpper(level.String())
+	cf.paint(sb, cf.color(level), name)
+	if pad := consoleLevelWidth - len(name); pad > 0 {
+		sb.WriteString(strings.Repeat(" ", pad))
+	}
+	sb.WriteByte(' ')
+	sb.WriteString(strings.TrimSuffix(fields.Message(), "\n"))
+
+	fields.Custom().Walk(func(key FieldKey, val interface{}) bool {
+		s := logfmtvalue(val)
+		if logfmtquote(s) {
+			s = strconv.Quote(s)
+		}
+		sb.WriteString("  ")
+		cf.paint(sb, ColorCyan, string(key)+"=")
+		sb.WriteString(s)
+		return true
+	})
+	sb.WriteByte('\n')
+
+	if ei := NewErrorInfo(fields.Error()); ei != nil {
+		cf.writeerror(sb, ei, indent, "error: ")
+	}
+	if file := fields.File(); file != "" {
+		sb.WriteString(indent)
+		cf.paint(sb, ColorGray, "caller: ")
+		sb.WriteString(file + ":" + strconv.Itoa(fields.Line()))
+		sb.WriteByte('\n')
+	}
+	if frames := fields.Frames(); frames != nil {
+		sb.WriteString(indent)
+		cf.paint(sb, ColorGray, "stack:")
+		sb.WriteByte('\n')
+		for _, frame := range frames {
+			sb.WriteString(indent + indent)
+			sb.WriteString(frame.File() + ":" + strconv.Itoa(frame.Line()))
+			if fn := frame.Func(); fn != "" {
+				sb.WriteByte(' ')
+				cf.paint(sb, ColorGray, fn)
+			}
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
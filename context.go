@@ -0,0 +1,56 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import "context"
+
+// contextkey is the context key of a Log.
+type contextkey struct{}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l Log) context.Context {
+	return context.WithValue(ctx, contextkey{}, l)
+}
+
+// FromContext returns the Log carried by ctx or the default logger if ctx
+// carries none.
+func FromContext(ctx context.Context) Log {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextkey{}).(Log); ok {
+			return l
+		}
+	}
+	return logger
+}
+
+// ContextExtractor is a prototype of a func that sets fields extracted from
+// a context. Extractors are registered with Logger.AddContextExtractor and
+// called for each line logged using a *Ctx method or WithContext.
+type ContextExtractor func(ctx context.Context, fields *Fields)
+
+// ContextValueExtractor returns a ContextExtractor that sets the value of
+// ctx under key to field under specified key if the value is not nil.
+func ContextValueExtractor(key interface{}, field FieldKey) ContextExtractor {
+	return func(ctx context.Context, fields *Fields) {
+		if val := ctx.Value(key); val != nil {
+			fields.Set(field, val)
+		}
+	}
+}
+
+// AddContextExtractor registers a ContextExtractor. Extractors are called
+// in order of registration.
+func (l *Logger) AddContextExtractor(ce ContextExtractor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.extractors = append(l.extractors, ce)
+}
+
+// contextextractors returns registered context extractors.
+func (l *Logger) contextextractors() []ContextExtractor {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.extractors
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// KeyRepeated specifies that field carries the number of times a
+	// suppressed duplicate line was repeated.
+	KeyRepeated FieldKey = "repeated"
+	// KeyFirstRepeat specifies that field carries the time of the first
+	// suppressed duplicate line.
+	KeyFirstRepeat FieldKey = "first_repeat"
+	// KeyLastRepeat specifies that field carries the time of the last
+	// suppressed duplicate line.
+	KeyLastRepeat FieldKey = "last_repeat"
+)
+
+// DedupOptions defines duplicate line suppression options of an output.
+//
+// Consecutive lines with identical level, message, error and custom fields
+// are suppressed and summarized by a line with the same level and message
+// "last message repeated N times" carrying KeyRepeated, KeyFirstRepeat and
+// KeyLastRepeat fields. The summary is written when a different line is
+// logged, when a duplicate is logged Window or more after the first
+// suppressed duplicate or when the Logger is flushed or closed. There is
+// no timer; a summary of a stream of duplicates that stops is written only
+// by the next different line, a flush or a close.
+type DedupOptions struct {
+	// Window is the time after which the next duplicate writes a summary
+	// of suppressed duplicates. If 0, duplicates are summarized only when
+	// a different line is logged or on flush.
+	Window time.Duration
+}
+
+// dedup suppresses consecutive duplicate lines.
+type dedup struct {
+	opts      DedupOptions
+	signature string
+	level     LogLevel
+	order     FieldOrder
+	count     int
+	first     time.Time
+	last      time.Time
+}
+
+// newdedup returns a new dedup or nil if opts is nil.
+func newdedup(opts *DedupOptions) *dedup {
+	if opts == nil {
+		return nil
+	}
+	return &dedup{opts: *opts}
+}
+
+// linesignature returns a signature of level, message, error and custom
+// fields of a line.
+func linesignature(fields *Fields) string {
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "%d\x00%s\x00", fields.LogLevel(), fields.Message())
+	if err := fields.Error(); err != nil {
+		sb.WriteString(err.Error())
+	}
+	pairs := []string{}
+	fields.Custom().Walk(func(key FieldKey, val interface{}) bool {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, val))
+		return true
+	})
+	sort.Strings(pairs)
+	for _, pair := range pairs {
+		sb.WriteByte(0)
+		sb.WriteString(pair)
+	}
+	return sb.String()
+}
+
+// filter returns lines to write for a logged line, i.e. nothing if line is
+// a suppressed duplicate or a summary of suppressed lines and the line.
+func (d *dedup) filter(fields *Fields) (lines []*Fields) {
+	signature := linesignature(fields)
+	if signature == d.signature {
+		now := fields.Time()
+		if now.IsZero() {
+			now = time.Now()
+		}
+		if d.count == 0 {
+			d.first = now
+		}
+		d.count++
+		d.last = now
+		if d.opts.Window > 0 && d.last.Sub(d.first) >= d.opts.Window {
+			lines = append(lines, d.summary())
+		}
+		return
+	}
+	if d.count > 0 {
+		lines = append(lines, d.summary())
+	}
+	d.signature = signature
+	d.level = fields.LogLevel()
+	d.order = fields.Order()
+	return append(lines, fields)
+}
+
+// summary returns a summary line of suppressed lines and resets the count
+// or returns nil if there are none.
+func (d *dedup) summary() *Fields {
+	if d.count == 0 {
+		return nil
+	}
+	f := NewFields()
+	f.SetOrder(d.order)
+	f.set(KeyTime, d.last)
+	f.set(KeyLogLevel, d.level)
+	f.set(KeyMessage, fmt.Sprintf("last message repeated %d times", d.count))
+	f.set(KeyRepeated, d.count)
+	f.set(KeyFirstRepeat, d.first)
+	f.set(KeyLastRepeat, d.last)
+	d.count = 0
+	return f
+}
@@ -4,6 +4,8 @@
 
 package logex
 
+import "context"
+
 var logger = New(nil)
 
 // Debugf logs a debug message formed from format string and args using the default logger.
@@ -30,6 +32,56 @@ func Errorf(err error, format string, args ...interface{}) { logger.Errorf(err,
 // Errorln logs an error and args as a warning message using the default logger.
 func Errorln(err error, args ...interface{}) { logger.Errorln(err, args...) }
 
+// Tracef logs a trace message formed from format string and args using the default logger.
+func Tracef(format string, args ...interface{}) { logger.Tracef(format, args...) }
+
+// Traceln logs args as a trace message using the default logger.
+func Traceln(args ...interface{}) { logger.Traceln(args...) }
+
+// Noticef logs a notice message formed from format string and args using the default logger.
+func Noticef(format string, args ...interface{}) { logger.Noticef(format, args...) }
+
+// Noticeln logs args as a notice message using the default logger.
+func Noticeln(args ...interface{}) { logger.Noticeln(args...) }
+
+// Criticalf logs an error and a critical message formed from format string and args using the default logger.
+func Criticalf(err error, format string, args ...interface{}) { logger.Criticalf(err, format, args...) }
+
+// Criticalln logs an error and args as a critical message using the default logger.
+func Criticalln(err error, args ...interface{}) { logger.Criticalln(err, args...) }
+
+// Alertf logs an error and an alert message formed from format string and args using the default logger.
+func Alertf(err error, format string, args ...interface{}) { logger.Alertf(err, format, args...) }
+
+// Alertln logs an error and args as an alert message using the default logger.
+func Alertln(err error, args ...interface{}) { logger.Alertln(err, args...) }
+
+// Emergencyf logs an error and an emergency message formed from format string and args using the default logger.
+func Emergencyf(err error, format string, args ...interface{}) {
+	logger.Emergencyf(err, format, args...)
+}
+
+// Emergencyln logs an error and args as an emergency message using the default logger.
+func Emergencyln(err error, args ...interface{}) { logger.Emergencyln(err, args...) }
+
+// Fatalf logs a critical message using the default logger, flushes it and exits the program with status 1.
+func Fatalf(err error, format string, args ...interface{}) { logger.Fatalf(err, format, args...) }
+
+// Fatalln logs a critical message using the default logger, flushes it and exits the program with status 1.
+func Fatalln(err error, args ...interface{}) { logger.Fatalln(err, args...) }
+
+// Panicf logs a critical message using the default logger, flushes it and panics with the message.
+func Panicf(err error, format string, args ...interface{}) { logger.Panicf(err, format, args...) }
+
+// Panicln logs a critical message using the default logger, flushes it and panics with the message.
+func Panicln(err error, args ...interface{}) { logger.Panicln(err, args...) }
+
+// Enabled returns true if a line with the specified level would be logged by the default logger.
+func Enabled(level LogLevel) bool { return logger.Enabled(level) }
+
+// V returns the default logger if level is enabled or a Log that discards everything otherwise.
+func V(level LogLevel) Log { return logger.V(level) }
+
 // Printf logs a message with a custom logging level formed from format string and args using the default logger.
 func Printf(level LogLevel, format string, args ...interface{}) {
 	logger.Printf(level, format, args...)
@@ -38,7 +90,7 @@ func Printf(level LogLevel, format string, args ...interface{}) {
 // Println logs args as a message with custom logging level using the default logger.
 func Println(level LogLevel, args ...interface{}) { logger.Println(level, args...) }
 
-// WithCaller appends the caller field to the next logged line using the default logger.
+// WithCaller appends the caller file, line and func fields to the next logged line using the default logger.
 func WithCaller(skip int) Log { return logger.WithCaller(skip) }
 
 // WithStack appends the stack field to the next logged line using the default logger.
@@ -46,3 +98,46 @@ func WithStack(skip int, depth int) Log { return logger.WithStack(skip, depth) }
 
 // WithFields appends the specified fields to the next logged line using the default logger.
 func WithFields(f *Fields) Log { return logger.WithFields(f) }
+
+// With returns a Log that appends the specified fields to every logged line using the default logger.
+func With(f *Fields) Log { return logger.With(f) }
+
+// WithContext returns a Log that appends fields extracted from ctx to every logged line using the default logger.
+func WithContext(ctx context.Context) Log { return logger.WithContext(ctx) }
+
+// AddContextExtractor registers a ContextExtractor with the default logger.
+func AddContextExtractor(ce ContextExtractor) { logger.AddContextExtractor(ce) }
+
+// DebugfCtx logs a debug message formed from format string and args with fields extracted from ctx using the default logger.
+func DebugfCtx(ctx context.Context, format string, args ...interface{}) {
+	logger.DebugfCtx(ctx, format, args...)
+}
+
+// DebuglnCtx logs args as a debug message with fields extracted from ctx using the default logger.
+func DebuglnCtx(ctx context.Context, args ...interface{}) { logger.DebuglnCtx(ctx, args...) }
+
+// InfofCtx logs an info message formed from format string and args with fields extracted from ctx using the default logger.
+func InfofCtx(ctx context.Context, format string, args ...interface{}) {
+	logger.InfofCtx(ctx, format, args...)
+}
+
+// InfolnCtx logs args as an info message with fields extracted from ctx using the default logger.
+func InfolnCtx(ctx context.Context, args ...interface{}) { logger.InfolnCtx(ctx, args...) }
+
+// WarningfCtx logs a warning message formed from format string and args with fields extracted from ctx using the default logger.
+func WarningfCtx(ctx context.Context, format string, args ...interface{}) {
+	logger.WarningfCtx(ctx, format, args...)
+}
+
+// WarninglnCtx logs args as a warning message with fields extracted from ctx using the default logger.
+func WarninglnCtx(ctx context.Context, args ...interface{}) { logger.WarninglnCtx(ctx, args...) }
+
+// ErrorfCtx logs an error and an error message formed from format string and args with fields extracted from ctx using the default logger.
+func ErrorfCtx(ctx context.Context, err error, format string, args ...interface{}) {
+	logger.ErrorfCtx(ctx, err, format, args...)
+}
+
+// ErrorlnCtx logs an error and args as a warning message with fields extracted from ctx using the default logger.
+func ErrorlnCtx(ctx context.Context, err error, args ...interface{}) {
+	logger.ErrorlnCtx(ctx, err, args...)
+}
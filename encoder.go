@@ -0,0 +1,358 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxpooledbuf is the maximum capacity of a buffer returned to linebufpool.
+const maxpooledbuf = 64 << 10
+
+// linebuf is a pooled, reference counted buffer holding a formatted line.
+type linebuf struct {
+	data []byte
+	refs int32
+}
+
+// linebufpool is a pool of linebufs.
+var linebufpool = sync.Pool{
+	New: func() interface{} {
+		return &linebuf{data: make([]byte, 0, 512)}
+	},
+}
+
+// newlinebuf returns an empty linebuf with one reference.
+func newlinebuf() *linebuf {
+	lb := linebufpool.Get().(*linebuf)
+	lb.data = lb.data[:0]
+	lb.refs = 1
+	return lb
+}
+
+// retain adds a reference to lb.
+func (lb *linebuf) retain() { atomic.AddInt32(&lb.refs, 1) }
+
+// release removes a reference from lb and returns it to linebufpool if
+// there are no more references.
+func (lb *linebuf) release() {
+	if atomic.AddInt32(&lb.refs, -1) == 0 && cap(lb.data) <= maxpooledbuf {
+		linebufpool.Put(lb)
+	}
+}
+
+// formatcache holds lines formatted during a single write to Logger
+// outputs so that outputs with the same formatter format a line once.
+type formatcache struct {
+	n       int
+	entries [4]struct {
+		f      Formatter
+		fields *Fields
+		lb     *linebuf
+	}
+}
+
+// format returns fields formatted by output o, reusing a line formatted by
+// another output with the same formatter if possible. The returned linebuf
+// must be released by the caller.
+func (fc *formatcache) format(o *output, fields *Fields) *linebuf {
+	if fc != nil && o.shared {
+		for i := 0; i < fc.n; i++ {
+			if e := &fc.entries[i]; e.fields == fields && e.f == o.f {
+				e.lb.retain()
+				return e.lb
+			}
+		}
+	}
+	lb := newlinebuf()
+	lb.data = o.af.AppendFormat(lb.data, fields)
+	if fc != nil && o.shared && fc.n < len(fc.entries) {
+		lb.retain()
+		e := &fc.entries[fc.n]
+		e.f, e.fields, e.lb = o.f, fields, lb
+		fc.n++
+	}
+	return lb
+}
+
+// release releases cached lines.
+func (fc *formatcache) release() {
+	for i := 0; i < fc.n; i++ {
+		fc.entries[i].lb.release()
+		fc.entries[i].f, fc.entries[i].fields, fc.entries[i].lb = nil, nil, nil
+	}
+	fc.n = 0
+}
+
+// hexdigits are lowercase hex digits.
+const hexdigits = "0123456789abcdef"
+
+// appendjsonstring appends s to dst as a JSON string escaped like
+// encoding/json does.
+func appendjsonstring(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= ' ' && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = append(dst, '\\', 'u', '0', '0', hexdigits[b>>4], hexdigits[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			dst = append(dst, s[start:i]...)
+			dst = append(dst, '\\', 'u', '2', '0', '2', hexdigits[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	return append(dst, '"')
+}
+
+// appendjsonfloat appends f to dst formatted like encoding/json does.
+func appendjsonfloat(dst []byte, f float64, bits int) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) ||
+			bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	dst = strconv.AppendFloat(dst, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(dst); n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+// appendjsonindent appends a newline and depth tabs to dst.
+func appendjsonindent(dst []byte, depth int) []byte {
+	dst = append(dst, '\n')
+	for i := 0; i < depth; i++ {
+		dst = append(dst, '\t')
+	}
+	return dst
+}
+
+// appendjsonfields appends fields to dst as a JSON object in walk order,
+// indented with tabs at depth if indent is true.
+func appendjsonfields(dst []byte, fields *Fields, indent bool, depth int) []byte {
+	dst = append(dst, '{')
+	n := 0
+	fields.each(false, func(key FieldKey, val interface{}) {
+		if n > 0 {
+			dst = append(dst, ',')
+		}
+		n++
+		if indent {
+			dst = appendjsonindent(dst, depth+1)
+		}
+		dst = appendjsonstring(dst, string(key))
+		dst = append(dst, ':')
+		if indent {
+			dst = append(dst, ' ')
+		}
+		dst = appendjsonvalue(dst, val, indent, depth+1)
+	})
+	if indent && n > 0 {
+		dst = appendjsonindent(dst, depth)
+	}
+	return append(dst, '}')
+}
+
+// appendjsontime appends t to dst as a JSON string formatted like
+// encoding/json does.
+func appendjsontime(dst []byte, t time.Time) []byte {
+	if y := t.Year(); y < 0 || y >= 10000 {
+		data, err := t.MarshalJSON()
+		if err != nil {
+			return appendjsonstring(dst, err.Error())
+		}
+		return append(dst, data...)
+	}
+	dst = append(dst, '"')
+	dst = t.AppendFormat(dst, time.RFC3339Nano)
+	return append(dst, '"')
+}
+
+// appendjsonvalue appends val to dst as JSON, indented with tabs at depth
+// if indent is true. Common types are encoded directly, a LogLevel as its
+// name, others using
+// encoding/json. Errors that do not implement json.Marshaler are encoded
+// as ErrorInfo. Values that fail to encode are encoded as a string
+// describing the failure.
+func appendjsonvalue(dst []byte, val interface{}, indent bool, depth int) []byte {
+	switch v := val.(type) {
+	case nil:
+		return append(dst, "null"...)
+	case string:
+		return appendjsonstring(dst, v)
+	case bool:
+		return strconv.AppendBool(dst, v)
+	case int:
+		return strconv.AppendInt(dst, int64(v), 10)
+	case int8:
+		return strconv.AppendInt(dst, int64(v), 10)
+	case int16:
+		return strconv.AppendInt(dst, int64(v), 10)
+	case int32:
+		return strconv.AppendInt(dst, int64(v), 10)
+	case int64:
+		return strconv.AppendInt(dst, v, 10)
+	case uint:
+		return strconv.AppendUint(dst, uint64(v), 10)
+	case uint8:
+		return strconv.AppendUint(dst, uint64(v), 10)
+	case uint16:
+		return strconv.AppendUint(dst, uint64(v), 10)
+	case uint32:
+		return strconv.AppendUint(dst, uint64(v), 10)
+	case uint64:
+		return strconv.AppendUint(dst, v, 10)
+	case float32:
+		if !math.IsNaN(float64(v)) && !math.IsInf(float64(v), 0) {
+			return appendjsonfloat(dst, float64(v), 32)
+		}
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			return appendjsonfloat(dst, v, 64)
+		}
+	case LogLevel:
+		return appendjsonstring(dst, v.String())
+	case time.Duration:
+		return strconv.AppendInt(dst, int64(v), 10)
+	case *stringslot:
+		return appendjsonstring(dst, v.s)
+	case *timeslot:
+		return appendjsontime(dst, v.t)
+	case time.Time:
+		return appendjsontime(dst, v)
+	case *Fields:
+		if v != nil {
+			return appendjsonfields(dst, v, indent, depth)
+		}
+	case []*Fields:
+		if v == nil {
+			return append(dst, "null"...)
+		}
+		dst = append(dst, '[')
+		for i, f := range v {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			if indent {
+				dst = appendjsonindent(dst, depth+1)
+			}
+			dst = appendjsonvalue(dst, f, indent, depth+1)
+		}
+		if indent && len(v) > 0 {
+			dst = appendjsonindent(dst, depth)
+		}
+		return append(dst, ']')
+	case json.Marshaler:
+	case error:
+		val = NewErrorInfo(v)
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return appendjsonstring(dst, err.Error())
+	}
+	if !indent {
+		return append(dst, data...)
+	}
+	buf := bytes.NewBuffer(dst)
+	prefix := make([]byte, depth)
+	for i := range prefix {
+		prefix[i] = '\t'
+	}
+	if err := json.Indent(buf, data, string(prefix), "\t"); err != nil {
+		return append(dst, data...)
+	}
+	return buf.Bytes()
+}
+
+// appendscalar appends val to dst formatted like fmt's %v verb and returns
+// true if val is a string, bool, integer or float. Otherwise it returns
+// dst unmodified and false.
+func appendscalar(dst []byte, val interface{}) ([]byte, bool) {
+	switch v := val.(type) {
+	case string:
+		return append(dst, v...), true
+	case bool:
+		return strconv.AppendBool(dst, v), true
+	case int:
+		return strconv.AppendInt(dst, int64(v), 10), true
+	case int8:
+		return strconv.AppendInt(dst, int64(v), 10), true
+	case int16:
+		return strconv.AppendInt(dst, int64(v), 10), true
+	case int32:
+		return strconv.AppendInt(dst, int64(v), 10), true
+	case int64:
+		return strconv.AppendInt(dst, v, 10), true
+	case uint:
+		return strconv.AppendUint(dst, uint64(v), 10), true
+	case uint8:
+		return strconv.AppendUint(dst, uint64(v), 10), true
+	case uint16:
+		return strconv.AppendUint(dst, uint64(v), 10), true
+	case uint32:
+		return strconv.AppendUint(dst, uint64(v), 10), true
+	case uint64:
+		return strconv.AppendUint(dst, v, 10), true
+	case float32:
+		return strconv.AppendFloat(dst, float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.AppendFloat(dst, v, 'g', -1, 64), true
+	}
+	return dst, false
+}
+
+// appendtextvalue appends val to dst formatted like fmt's %v verb.
+func appendtextvalue(dst []byte, val interface{}) []byte {
+	if d, ok := appendscalar(dst, val); ok {
+		return d
+	}
+	return fmt.Append(dst, val)
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxerrordepth limits the depth of error chains converted to ErrorInfo.
+const maxerrordepth = 32
+
+// ErrorInfo is a structured representation of an error and its cause
+// chain. JSONFormatter renders errors as ErrorInfo objects.
+type ErrorInfo struct {
+	// Message is the error message.
+	Message string `json:"message"`
+	// Type is the Go type of the error.
+	Type string `json:"type"`
+	// Data is the custom data of the error, if the error exposes it
+	// through a Data() interface{} method like errorex errors do.
+	Data interface{} `json:"data,omitempty"`
+	// Frames is the stack the error was created with, if the error exposes
+	// it through a Callers() []uintptr method.
+	Frames []*Fields `json:"frames,omitempty"`
+	// Cause is the error returned by errors.Unwrap, if any.
+	Cause *ErrorInfo `json:"cause,omitempty"`
+	// Causes are the errors returned by an Unwrap() []error method and the
+	// error returned by a Cause() error method like errorex errors have,
+	// if any.
+	Causes []*ErrorInfo `json:"causes,omitempty"`
+	// Extras are the errors returned by an Extras() []error method like
+	// errorex errors have, if any.
+	Extras []*ErrorInfo `json:"extras,omitempty"`
+}
+
+// NewErrorInfo returns err and its cause chain as ErrorInfo.
+// It returns nil if err is nil.
+func NewErrorInfo(err error) *ErrorInfo { return newerrorinfo(err, 0) }
+
+// newerrorinfo returns err as ErrorInfo at chain depth.
+func newerrorinfo(err error, depth int) *ErrorInfo {
+	if ep, ok := err.(*errorprinter); ok {
+		err = ep.err
+	}
+	if err == nil {
+		return nil
+	}
+	ei := &ErrorInfo{
+		Message: err.Error(),
+		Type:    fmt.Sprintf("%T", err),
+	}
+	if d, ok := err.(interface{ Data() interface{} }); ok {
+		if data := d.Data(); data != nil {
+			if _, err := json.Marshal(data); err != nil {
+				data = fmt.Sprint(data)
+			}
+			ei.Data = data
+		}
+	}
+	if c, ok := err.(interface{ Callers() []uintptr }); ok {
+		if callers := c.Callers(); len(callers) > 0 {
+			ei.Frames = callerframes(callers)
+		}
+	}
+	if depth >= maxerrordepth {
+		return ei
+	}
+	if u, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, cause := range u.Unwrap() {
+			if ci := newerrorinfo(cause, depth+1); ci != nil {
+				ei.Causes = append(ei.Causes, ci)
+			}
+		}
+	} else {
+		ei.Cause = newerrorinfo(errors.Unwrap(err), depth+1)
+	}
+	if c, ok := err.(interface{ Cause() error }); ok {
+		if ci := newerrorinfo(c.Cause(), depth+1); ci != nil {
+			ei.Causes = append(ei.Causes, ci)
+		}
+	}
+	if x, ok := err.(interface{ Extras() []error }); ok {
+		for _, extra := range x.Extras() {
+			if xi := newerrorinfo(extra, depth+1); xi != nil {
+				ei.Extras = append(ei.Extras, xi)
+			}
+		}
+	}
+	return ei
+}
+
+// appendtext appends ei to dst as a line indented by indent and prefixed
+// by prefix followed by its stack frames, causes as "caused by" lines and
+// extra errors as "extra" lines indented one level deeper.
+func (ei *ErrorInfo) appendtext(dst []byte, indent, prefix string) []byte {
+	dst = append(dst, indent...)
+	dst = append(dst, prefix...)
+	for i, line := range strings.Split(ei.Message, "\n") {
+		if i > 0 {
+			dst = append(dst, '\n')
+			dst = append(dst, indent...)
+		}
+		dst = append(dst, line...)
+	}
+	if ei.Data != nil {
+		dst = fmt.Appendf(dst, " %v", ei.Data)
+	}
+	dst = append(dst, '\n')
+	for _, frame := range ei.Frames {
+		dst = fmt.Appendf(dst, "%s\t%s (%d)\n", indent, frame.File(), frame.Line())
+	}
+	if ei.Cause != nil {
+		dst = ei.Cause.appendtext(dst, indent+"\t", "caused by: ")
+	}
+	for _, cause := range ei.Causes {
+		dst = cause.appendtext(dst, indent+"\t", "caused by: ")
+	}
+	for _, extra := range ei.Extras {
+		dst = extra.appendtext(dst, indent+"\t", "extra: ")
+	}
+	return dst
+}
@@ -5,7 +5,11 @@
 package logex
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
 	"time"
 )
@@ -50,33 +54,235 @@ func keyreserved(key FieldKey) (reserved bool) {
 	return
 }
 
+// reservedorder is the order of reserved keys when walking in
+// OrderReservedFirst order.
+var reservedorder = []FieldKey{
+	KeyTime, KeyLogLevel, KeyMessage, KeyError, KeyFile, KeyLine, KeyFunc, KeyFrames,
+}
+
+// FieldOrder defines the order in which Fields are walked.
+type FieldOrder int
+
+const (
+	// OrderInsertion walks fields in the order they were first set.
+	OrderInsertion FieldOrder = iota
+	// OrderSorted walks fields sorted by key.
+	OrderSorted
+	// OrderReservedFirst walks reserved fields in the order time, loglevel,
+	// message, error, file, line, func, frames followed by custom fields in
+	// insertion order.
+	OrderReservedFirst
+)
+
 type fieldsMap map[FieldKey]interface{}
 
+// fieldsData holds keys in insertion order and their values.
+type fieldsData struct {
+	keys   []FieldKey
+	values fieldsMap
+}
+
 // Fields maps keys to values in a log line.
+// Fields keep the insertion order of keys.
 type Fields struct {
-	mu sync.Mutex
-	fieldsMap
+	mu     sync.Mutex
+	data   *fieldsData
+	own    fieldsData
+	cow    bool
+	order  FieldOrder
+	pooled bool
+	tslot  timeslot
+	mslot  stringslot
+}
+
+// timeslot holds the time of a pooled line so that setting it does not
+// allocate. Methods returning field values return the time it holds.
+type timeslot struct{ t time.Time }
+
+// stringslot holds the message of a pooled line so that setting it does
+// not allocate. Methods returning field values return the string it holds.
+type stringslot struct{ s string }
+
+// unslot returns the value held by a slot or val if val is not a slot.
+func unslot(val interface{}) interface{} {
+	switch v := val.(type) {
+	case *timeslot:
+		return v.t
+	case *stringslot:
+		return v.s
+	}
+	return val
+}
+
+// maxpooledfields is the maximum number of fields in Fields returned to
+// fieldspool.
+const maxpooledfields = 64
+
+// fieldspool is a pool of Fields of logged lines.
+var fieldspool = sync.Pool{
+	New: func() interface{} { return newfields(8) },
+}
+
+// getfields returns empty Fields for a logged line from fieldspool.
+// The Logger releases them once the line is written.
+func getfields() *Fields {
+	f := fieldspool.Get().(*Fields)
+	f.pooled = true
+	return f
+}
+
+// release resets f and returns it to fieldspool if f was returned by
+// getfields. f must not be used after release.
+func (f *Fields) release() {
+	if !f.pooled || f.cow || f.data != &f.own || len(f.own.keys) > maxpooledfields {
+		return
+	}
+	clear(f.own.values)
+	clear(f.own.keys)
+	f.own.keys = f.own.keys[:0]
+	f.order, f.pooled = OrderInsertion, false
+	f.tslot, f.mslot = timeslot{}, stringslot{}
+	fieldspool.Put(f)
+}
+
+// settime sets the time field of pooled Fields without allocating.
+func (f *Fields) settime(t time.Time) {
+	f.tslot.t = t
+	f.set(KeyTime, &f.tslot)
+}
+
+// setmessage sets the message field of pooled Fields without allocating.
+func (f *Fields) setmessage(message string) {
+	f.mslot.s = message
+	f.set(KeyMessage, &f.mslot)
 }
 
 // NewFields creates new Fields.
-func NewFields() *Fields {
+func NewFields() *Fields { return newfields(0) }
+
+// newfields creates new Fields with space for size fields.
+func newfields(size int) *Fields {
+	f := &Fields{mu: sync.Mutex{}}
+	f.own.keys = make([]FieldKey, 0, size)
+	f.own.values = make(fieldsMap, size)
+	f.data = &f.own
+	return f
+}
+
+// emptyfields is the data of zero value Fields.
+var emptyfields = &fieldsData{}
+
+// d returns f data.
+// f must be locked.
+func (f *Fields) d() *fieldsData {
+	if f.data == nil {
+		return emptyfields
+	}
+	return f.data
+}
+
+// Clone returns a copy of Fields.
+// Data is shared until either Fields is modified.
+func (f *Fields) Clone() *Fields {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.pooled {
+		// Pooled data is reused once the line is written.
+		data := f.d()
+		cf := newfields(len(data.keys))
+		cf.order = f.order
+		for _, key := range data.keys {
+			cf.own.keys = append(cf.own.keys, key)
+			cf.own.values[key] = unslot(data.values[key])
+		}
+		return cf
+	}
+	f.cow = true
 	return &Fields{
-		mu:        sync.Mutex{},
-		fieldsMap: make(fieldsMap),
+		mu:    sync.Mutex{},
+		data:  f.d(),
+		cow:   true,
+		order: f.order,
 	}
 }
 
+// SetOrder sets the order in which Walk walks Fields.
+func (f *Fields) SetOrder(order FieldOrder) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.order = order
+}
+
+// Order returns the order in which Walk walks Fields.
+func (f *Fields) Order() FieldOrder {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.order
+}
+
 // UnmarshalJSON unmarshals fields from JSON data or retutns an error.
-func (f *Fields) UnmarshalJSON(data []byte) error { return json.Unmarshal(data, &f.fieldsMap) }
+// Keys are set in the order they appear in data.
+func (f *Fields) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return err
+	} else if tok != json.Delim('{') {
+		return &json.UnmarshalTypeError{Value: fmt.Sprint(tok), Type: reflect.TypeOf(f)}
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if key := FieldKey(tok.(string)); key == KeyLogLevel {
+			var lvl LogLevel
+			if err := dec.Decode(&lvl); err != nil {
+				return err
+			}
+			f.set(key, lvl)
+			continue
+		}
+		var val interface{}
+		if err := dec.Decode(&val); err != nil {
+			return err
+		}
+		f.set(FieldKey(tok.(string)), val)
+	}
+	_, err := dec.Token()
+	return err
+}
 
-// MarshalJSON marshals fields to JSON data or returns an error.
-func (f *Fields) MarshalJSON() ([]byte, error) { return json.Marshal(f.fieldsMap) }
+// MarshalJSON marshals fields to JSON data in walk order.
+// Errors that do not implement json.Marshaler are marshaled as ErrorInfo
+// and values that fail to marshal as a string describing the failure.
+func (f *Fields) MarshalJSON() ([]byte, error) {
+	return appendjsonfields(nil, f, false, 0), nil
+}
 
 // set sets a field under key to value.
+// A new key is appended, an existing key keeps its position.
 func (f *Fields) set(key FieldKey, value interface{}) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	f.fieldsMap[key] = value
+	if f.data == nil {
+		f.own.values = make(fieldsMap)
+		f.data = &f.own
+	}
+	if f.cow {
+		data := &fieldsData{
+			keys:   make([]FieldKey, len(f.data.keys), len(f.data.keys)+1),
+			values: make(fieldsMap, len(f.data.values)+1),
+		}
+		copy(data.keys, f.data.keys)
+		for key, val := range f.data.values {
+			data.values[key] = unslot(val)
+		}
+		f.data, f.cow = data, false
+	}
+	if _, exists := f.data.values[key]; !exists {
+		f.data.keys = append(f.data.keys, key)
+	}
+	f.data.values[key] = value
 }
 
 // Set sets a custom field under key to value.
@@ -93,21 +299,67 @@ func (f *Fields) Set(key FieldKey, value interface{}) error {
 func (f *Fields) Get(key FieldKey) (val interface{}, exists bool) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	val, exists = f.fieldsMap[key]
-	return
+	val, exists = f.d().values[key]
+	return unslot(val), exists
+}
+
+// raw returns the value of a field without unwrapping slots.
+func (f *Fields) raw(key FieldKey) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.d().values[key]
 }
 
 // Len returns number of fields.
 func (f *Fields) Len() int {
-	return len(f.fieldsMap)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.d().keys)
+}
+
+// Keys returns field keys in walk order.
+func (f *Fields) Keys() []FieldKey {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.orderedkeys(f.order)
+}
+
+// orderedkeys returns a copy of keys in order.
+// f must be locked.
+func (f *Fields) orderedkeys(order FieldOrder) []FieldKey {
+	data := f.d()
+	keys := make([]FieldKey, 0, len(data.keys))
+	switch order {
+	case OrderSorted:
+		keys = append(keys, data.keys...)
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	case OrderReservedFirst:
+		for _, key := range reservedorder {
+			if _, exists := data.values[key]; exists {
+				keys = append(keys, key)
+			}
+		}
+		for _, key := range data.keys {
+			if !keyreserved(key) {
+				keys = append(keys, key)
+			}
+		}
+	default:
+		keys = append(keys, data.keys...)
+	}
+	return keys
 }
 
 // Custom returns custom fields
 func (f *Fields) Custom() *Fields {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 	cf := NewFields()
-	for key, val := range f.fieldsMap {
+	cf.order = f.order
+	data := f.d()
+	for _, key := range data.keys {
 		if !keyreserved(key) {
-			cf.set(key, val)
+			cf.set(key, data.values[key])
 		}
 	}
 	return cf
@@ -116,37 +368,91 @@ func (f *Fields) Custom() *Fields {
 // WalkFunc is a prototype of a func Walk calls.
 type WalkFunc = func(key FieldKey, val interface{}) bool
 
-// Walk walks the fields and calls f for each field.
-// f should return true to continue the walk.
+// Walk walks the fields in the order set by SetOrder and calls f for each
+// field. f should return true to continue the walk.
 // Walk returns an error if f is invalid.
+// Walk iterates over a snapshot of fields so f may modify them.
 func (f *Fields) Walk(wf WalkFunc) error {
+	return f.WalkOrdered(f.Order(), wf)
+}
+
+// WalkOrdered is like Walk but walks the fields in the specified order.
+func (f *Fields) WalkOrdered(order FieldOrder, wf WalkFunc) error {
 	if wf == nil {
 		return ErrInvalidWalkFunc
 	}
-	for key, val := range f.fieldsMap {
-		if !wf(key, val) {
+	f.mu.Lock()
+	keys := f.orderedkeys(order)
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = unslot(f.d().values[key])
+	}
+	f.mu.Unlock()
+	for i, key := range keys {
+		if !wf(key, values[i]) {
 			break
 		}
 	}
 	return nil
 }
 
+// each calls fn for each field in walk order, or only for custom fields
+// if custom is true, while f is locked. fn must not call methods of f.
+// Time and message values of logged lines are passed as *timeslot and
+// *stringslot.
+func (f *Fields) each(custom bool, fn func(key FieldKey, val interface{})) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data := f.d()
+	switch f.order {
+	case OrderSorted:
+		for _, key := range f.orderedkeys(OrderSorted) {
+			if !custom || !keyreserved(key) {
+				fn(key, data.values[key])
+			}
+		}
+	case OrderReservedFirst:
+		if !custom {
+			for _, key := range reservedorder {
+				if val, exists := data.values[key]; exists {
+					fn(key, val)
+				}
+			}
+		}
+		for _, key := range data.keys {
+			if !keyreserved(key) {
+				fn(key, data.values[key])
+			}
+		}
+	default:
+		for _, key := range data.keys {
+			if !custom || !keyreserved(key) {
+				fn(key, data.values[key])
+			}
+		}
+	}
+}
+
 // Time returns Time field.
 func (f *Fields) Time() time.Time {
-	t, ok := f.Get(KeyTime)
-	if !ok {
-		return time.Time{}
+	switch t := f.raw(KeyTime).(type) {
+	case *timeslot:
+		return t.t
+	case time.Time:
+		return t
 	}
-	return t.(time.Time)
+	return time.Time{}
 }
 
 // Message returns message field.
 func (f *Fields) Message() string {
-	msg, ok := f.Get(KeyMessage)
-	if !ok {
-		return ""
+	switch msg := f.raw(KeyMessage).(type) {
+	case *stringslot:
+		return msg.s
+	case string:
+		return msg
 	}
-	return msg.(string)
+	return ""
 }
 
 // LogLevel returns log level field.
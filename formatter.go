@@ -5,17 +5,47 @@
 package logex
 
 import (
-	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 )
 
 // Formatter formats Fields to a custom format.
 type Formatter interface {
 	// Format must return a string representation of key/value pairs, such as JSON object, CSV, custom.
+	// Fields are reused after Format returns and must not be retained; use Fields.Clone to keep them.
 	Format(*Fields) string
 }
 
-// SimpleFormatter sorts Fields alphabetically and appends them as "key"="value" pairs separated by space.
+// AppendFormatter formats Fields by appending to a byte slice.
+// Logger formats lines into pooled buffers using AppendFormatter if an
+// output Formatter implements it.
+type AppendFormatter interface {
+	// AppendFormat must append a representation of Fields to dst and
+	// return the extended slice. Fields must not be retained.
+	AppendFormat(dst []byte, fields *Fields) []byte
+}
+
+// NewAppendFormatter returns f as an AppendFormatter. If f does not
+// implement AppendFormatter the result of its Format is appended.
+func NewAppendFormatter(f Formatter) AppendFormatter {
+	if af, ok := f.(AppendFormatter); ok {
+		return af
+	}
+	return &formatterappender{f}
+}
+
+// formatterappender adapts a Formatter to an AppendFormatter.
+type formatterappender struct{ f Formatter }
+
+// AppendFormat implements AppendFormatter interface.
+func (fa *formatterappender) AppendFormat(dst []byte, fields *Fields) []byte {
+	return append(dst, fa.f.Format(fields)...)
+}
+
+// SimpleFormatter appends custom Fields in walk order as "key"="value" pairs separated by space.
 type SimpleFormatter struct{}
 
 // NewSimpleFormatter returns a new SimpleFormatter.
@@ -23,35 +53,71 @@ func NewSimpleFormatter() Formatter { return &SimpleFormatter{} }
 
 // Format implements Formatter interface.
 func (sf SimpleFormatter) Format(fields *Fields) string {
+	return string(sf.AppendFormat(nil, fields))
+}
 
-	const TimeStampFormat = "2006-02-01 15:04:05"
-
-	s := fmt.Sprintf("[%s] %s: %s",
-		fields.Time().Format(TimeStampFormat),
-		fields.LogLevel(),
-		fields.Message())
-	f := fields.Custom()
-	if f.Len() > 0 {
-		fs := ""
-		f.Walk(func(key FieldKey, val interface{}) bool {
-			fs += fmt.Sprintf("\"%s\"=\"%v\"", key, val)
-			return true
-		})
-		s += fs + "\n"
+// AppendFormat implements AppendFormatter interface.
+func (sf SimpleFormatter) AppendFormat(dst []byte, fields *Fields) []byte {
+
+	const TimeStampFormat = "2006-01-02 15:04:05"
+
+	dst = append(dst, '[')
+	dst = fields.Time().AppendFormat(dst, TimeStampFormat)
+	dst = append(dst, "] "...)
+	dst = append(dst, fields.LogLevel().String()...)
+	dst = append(dst, ": "...)
+	dst = append(dst, fields.Message()...)
+	custom, errs := false, false
+	fields.each(true, func(key FieldKey, val interface{}) {
+		custom = true
+		if _, ok := val.(error); ok {
+			errs = true
+			return
+		}
+		dst = append(dst, " \""...)
+		dst = append(dst, key...)
+		dst = append(dst, "\"=\""...)
+		dst = appendtextvalue(dst, val)
+		dst = append(dst, '"')
+	})
+	if custom {
+		dst = append(dst, '\n')
 	}
 	if err := fields.Error(); err != nil {
-		s += fmt.Sprintf("\tError:\n\t%s\n", err)
+		dst = append(dst, "\tError:\n"...)
+		dst = NewErrorInfo(err).appendtext(dst, "\t", "")
+	}
+	if errs {
+		for _, key := range fields.Keys() {
+			val, _ := fields.Get(key)
+			if err, ok := val.(error); ok && !keyreserved(key) {
+				dst = append(dst, '\t')
+				dst = append(dst, key...)
+				dst = append(dst, ":\n"...)
+				dst = NewErrorInfo(err).appendtext(dst, "\t", "")
+			}
+		}
 	}
 	if file := fields.File(); file != "" {
-		s += fmt.Sprintf("\tCaller:\n\t%s (%d)\n", fields.File(), fields.Line())
+		dst = append(dst, "\tCaller:\n\t"...)
+		dst = append(dst, file...)
+		dst = append(dst, " ("...)
+		dst = strconv.AppendInt(dst, int64(fields.Line()), 10)
+		dst = append(dst, ")\n"...)
 	}
 	if frames := fields.Frames(); frames != nil {
-		s += fmt.Sprintf("\tStack:\n")
+		dst = append(dst, "\tStack:\n"...)
 		for _, frame := range frames {
-			s += fmt.Sprintf("\t%s (%d)\n\t\t%s\n", frame.File(), frame.Line(), frame.Func())
+			dst = append(dst, '\t')
+			dst = append(dst, frame.File()...)
+			dst = append(dst, " ("...)
+			dst = strconv.AppendInt(dst, int64(frame.Line()), 10)
+			dst = append(dst, ")\n\t\t"...)
+			dst = append(dst, frame.Func()...)
+			dst = append(dst, '\n')
 		}
 	}
-	return s
+	return dst
 }
 
 // JSONFormatter formats Fields into a JSON object.
@@ -62,15 +128,134 @@ func NewJSONFormatter(indent bool) Formatter { return &JSONFormatter{indent} }
 
 // Format implements Formatter interface.
 func (jf *JSONFormatter) Format(fields *Fields) string {
-	var buf []byte
-	var err error
-	if jf.indent {
-		buf, err = json.MarshalIndent(fields, "", "\t")
-	} else {
-		buf, err = json.Marshal(fields)
+	return string(jf.AppendFormat(nil, fields))
+}
+
+// AppendFormat implements AppendFormatter interface.
+func (jf *JSONFormatter) AppendFormat(dst []byte, fields *Fields) []byte {
+	dst = appendjsonfields(dst, fields, jf.indent, 0)
+	return append(dst, '\n')
+}
+
+// LogfmtFormatter formats Fields as a logfmt line of key=value pairs in the
+// following order: time, level, msg, error, caller, stack and custom fields
+// in walk order. Values containing spaces, quotes, '=' or control
+// characters are quoted.
+type LogfmtFormatter struct{}
+
+// NewLogfmtFormatter returns a new LogfmtFormatter.
+func NewLogfmtFormatter() Formatter { return &LogfmtFormatter{} }
+
+// Format implements Formatter interface.
+func (lf *LogfmtFormatter) Format(fields *Fields) string {
+	return string(lf.AppendFormat(nil, fields))
+}
+
+// AppendFormat implements AppendFormatter interface.
+func (lf *LogfmtFormatter) AppendFormat(dst []byte, fields *Fields) []byte {
+	dst = append(dst, "time="...)
+	dst = fields.Time().AppendFormat(dst, time.RFC3339Nano)
+	dst = append(dst, " level="...)
+	start := len(dst)
+	dst = append(dst, fields.LogLevel().String()...)
+	for i := start; i < len(dst); i++ {
+		if c := dst[i]; c >= 'A' && c <= 'Z' {
+			dst[i] = c + 'a' - 'A'
+		}
+	}
+	dst = append(dst, " msg="...)
+	dst = appendlogfmtstring(dst, strings.TrimSuffix(fields.Message(), "\n"))
+	if err := fields.Error(); err != nil {
+		dst = append(dst, " error="...)
+		dst = appendlogfmtstring(dst, err.Error())
 	}
-	if err != nil {
-		return err.Error()
+	if file := fields.File(); file != "" {
+		dst = append(dst, " caller="...)
+		if logfmtquote(file) {
+			dst = appendlogfmtstring(dst, file+":"+strconv.Itoa(fields.Line()))
+		} else {
+			dst = append(dst, file...)
+			dst = append(dst, ':')
+			dst = strconv.AppendInt(dst, int64(fields.Line()), 10)
+		}
+	}
+	if frames := fields.Frames(); frames != nil {
+		stack := make([]string, 0, len(frames))
+		for _, frame := range frames {
+			stack = append(stack, frame.File()+":"+strconv.Itoa(frame.Line()))
+		}
+		dst = append(dst, " stack="...)
+		dst = appendlogfmtstring(dst, strings.Join(stack, ","))
+	}
+	fields.each(true, func(key FieldKey, val interface{}) {
+		dst = append(dst, ' ')
+		dst = append(dst, logfmtkey(string(key))...)
+		dst = append(dst, '=')
+		dst = appendlogfmtvalue(dst, val)
+	})
+	return append(dst, '\n')
+}
+
+// appendlogfmtstring appends s to dst, quoting it if required.
+func appendlogfmtstring(dst []byte, s string) []byte {
+	if logfmtquote(s) {
+		return strconv.AppendQuote(dst, s)
+	}
+	return append(dst, s...)
+}
+
+// appendlogfmtvalue appends a custom field value to dst, quoting it if
+// required.
+func appendlogfmtvalue(dst []byte, val interface{}) []byte {
+	if s, ok := val.(string); ok {
+		return appendlogfmtstring(dst, s)
+	}
+	if d, ok := appendscalar(dst, val); ok {
+		return d
+	}
+	return appendlogfmtstring(dst, logfmtvalue(val))
+}
+
+// logfmtquote returns if a logfmt value s must be quoted.
+func logfmtquote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == unicode.ReplacementChar || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// logfmtkey returns key with characters not allowed in a logfmt key
+// replaced with an underscore.
+func logfmtkey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtvalue returns a string representation of a custom field value.
+func logfmtvalue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return "nil"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
 	}
-	return string(buf) + "\n"
+	return fmt.Sprint(val)
 }
@@ -1,6 +1,6 @@
 module github.com/vedranvuk/logex
 
-go 1.14
+go 1.21
 
 require github.com/vedranvuk/errorex v0.3.1
 
@@ -0,0 +1,129 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultJournalSocket is the path of the journald native protocol socket.
+const DefaultJournalSocket = "/run/systemd/journal/socket"
+
+// JournalFormatter formats Fields as a systemd-journald native protocol
+// entry to be written to a JournalWriter.
+//
+// The message is written as MESSAGE, logging level as PRIORITY using the
+// same mapping as SyslogFormatter, error as ERROR, caller as CODE_FILE,
+// CODE_LINE and CODE_FUNC and stack as STACK. Custom field keys are
+// converted to upper case with characters other than letters, digits and
+// underscores replaced with underscores. Custom fields whose names would
+// equal a field written by the formatter or another field journald
+// interprets are prefixed with "CUSTOM_".
+type JournalFormatter struct {
+	identifier string
+}
+
+// NewJournalFormatter returns a new JournalFormatter. identifier is the
+// SYSLOG_IDENTIFIER of entries and defaults to the executable name.
+func NewJournalFormatter(identifier string) Formatter {
+	if identifier == "" && len(os.Args) > 0 {
+		identifier = filepath.Base(os.Args[0])
+	}
+	return &JournalFormatter{identifier}
+}
+
+// Format implements Formatter interface.
+func (jf *JournalFormatter) Format(fields *Fields) string {
+	sb := &strings.Builder{}
+	journalfield(sb, "MESSAGE", strings.TrimSuffix(fields.Message(), "\n"))
+	journalfield(sb, "PRIORITY", strconv.Itoa(syslogseverity(fields.LogLevel())))
+	if jf.identifier != "" {
+		journalfield(sb, "SYSLOG_IDENTIFIER", jf.identifier)
+	}
+	if err := fields.Error(); err != nil {
+		journalfield(sb, "ERROR", err.Error())
+	}
+	if file := fields.File(); file != "" {
+		journalfield(sb, "CODE_FILE", file)
+		journalfield(sb, "CODE_LINE", strconv.Itoa(fields.Line()))
+	}
+	if fn := fields.Func(); fn != "" {
+		journalfield(sb, "CODE_FUNC", fn)
+	}
+	if frames := fields.Frames(); frames != nil {
+		stack := make([]string, 0, len(frames))
+		for _, frame := range frames {
+			stack = append(stack, frame.File()+":"+strconv.Itoa(frame.Line())+" "+frame.Func())
+		}
+		journalfield(sb, "STACK", strings.Join(stack, "\n"))
+	}
+	fields.Custom().Walk(func(key FieldKey, val interface{}) bool {
+		if name := journalname(string(key)); name != "" {
+			journalfield(sb, name, logfmtvalue(val))
+		}
+		return true
+	})
+	return sb.String()
+}
+
+// journalfield writes a field to sb using the binary safe form if value
+// contains a newline.
+func journalfield(sb *strings.Builder, name, value string) {
+	sb.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		sb.WriteByte('=')
+		sb.WriteString(value)
+		sb.WriteByte('\n')
+		return
+	}
+	sb.WriteByte('\n')
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(value)))
+	sb.Write(size)
+	sb.WriteString(value)
+	sb.WriteByte('\n')
+}
+
+// journalreserved are journal field names not used for custom fields.
+var journalreserved = map[string]struct{}{
+	"MESSAGE":           {},
+	"MESSAGE_ID":        {},
+	"PRIORITY":          {},
+	"SYSLOG_IDENTIFIER": {},
+	"SYSLOG_FACILITY":   {},
+	"SYSLOG_PID":        {},
+	"ERROR":             {},
+	"CODE_FILE":         {},
+	"CODE_LINE":         {},
+	"CODE_FUNC":         {},
+	"STACK":             {},
+}
+
+// journalname returns key as a valid journal field name or an empty
+// string if key cannot be converted. Reserved names are prefixed with
+// "CUSTOM_".
+func journalname(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+	name = strings.TrimLeft(name, "_0123456789")
+	if _, reserved := journalreserved[name]; reserved {
+		name = "CUSTOM_" + name
+	}
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// JournalWriter is an io.WriteCloser that sends each Write as a single
+// entry to systemd-journald over its native protocol datagram socket.
+// Entries too large for a datagram are written to an unlinked temporary
+// file whose descriptor is passed to journald instead.
+type JournalWriter struct {
+	mu     sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+}
+
+// DialJournal connects to the journald socket at path and returns a new
+// JournalWriter or an error. If path is empty DefaultJournalSocket is used.
+func DialJournal(path string) (*JournalWriter, error) {
+	if path == "" {
+		path = DefaultJournalSocket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &JournalWriter{conn: conn}, nil
+}
+
+// Write implements io.Writer.
+func (jw *JournalWriter) Write(p []byte) (n int, err error) {
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	if jw.closed {
+		return 0, ErrClosed
+	}
+	if _, err = jw.conn.Write(p); err == nil {
+		return len(p), nil
+	}
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return 0, err
+	}
+	if err = jw.sendfd(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// sendfd writes p to an unlinked temporary file and sends its descriptor.
+// The file is created in /dev/shm if available so it stays in memory.
+func (jw *JournalWriter) sendfd(p []byte) error {
+	dir := "/dev/shm"
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = os.TempDir()
+	}
+	file, err := ioutil.TempFile(dir, "logex-journal-")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := os.Remove(file.Name()); err != nil {
+		return err
+	}
+	if _, err := file.Write(p); err != nil {
+		return err
+	}
+	// net rejects WriteMsgUnix on connected datagram sockets.
+	rc, err := jw.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	rights := syscall.UnixRights(int(file.Fd()))
+	var serr error
+	if err = rc.Write(func(fd uintptr) bool {
+		serr = syscall.Sendmsg(int(fd), nil, rights, nil, 0)
+		return serr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// Close closes the connection to journald.
+func (jw *JournalWriter) Close() error {
+	jw.mu.Lock()
+	defer jw.mu.Unlock()
+	if jw.closed {
+		return ErrClosed
+	}
+	jw.closed = true
+	return jw.conn.Close()
+}
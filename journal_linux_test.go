@@ -0,0 +1,148 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// parsejournal parses a native protocol entry.
+func parsejournal(t *testing.T, data []byte) map[string]string {
+	result := make(map[string]string)
+	for len(data) > 0 {
+		nl := strings.IndexByte(string(data), '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field: %q", data)
+		}
+		line := string(data[:nl])
+		data = data[nl+1:]
+		if eq := strings.IndexByte(line, '='); eq >= 0 {
+			result[line[:eq]] = line[eq+1:]
+			continue
+		}
+		size := binary.LittleEndian.Uint64(data[:8])
+		result[line] = string(data[8 : 8+size])
+		data = data[8+size+1:]
+	}
+	return result
+}
+
+func TestJournalWriter(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "logex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "journal.socket")
+	ln, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	jw, err := DialJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer jw.Close()
+
+	l := New(func(err error) { t.Fatal(err) })
+	l.AddOutput("journal", jw, NewJournalFormatter("test"))
+
+	f := NewFields()
+	f.Set("request-id", "abc")
+	l.WithCaller(1).WithFields(f).Warningf("multi\nline")
+
+	buf := make([]byte, 65536)
+	oob := make([]byte, 1024)
+	ln.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, _, _, err := ln.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := parsejournal(t, buf[:n])
+	for key, val := range map[string]string{
+		"MESSAGE":           "multi\nline",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "test",
+		"REQUEST_ID":        "abc",
+	} {
+		if entry[key] != val {
+			t.Fatalf("expected %s=%q, got %q", key, val, entry[key])
+		}
+	}
+	if !strings.HasSuffix(entry["CODE_FILE"], "journal_linux_test.go") || entry["CODE_LINE"] == "" ||
+		!strings.HasSuffix(entry["CODE_FUNC"], ".TestJournalWriter") {
+		t.Fatalf("missing caller fields: %v", entry)
+	}
+
+	large := strings.Repeat("x", 1<<22)
+	l.Infof(large)
+	n, oobn, _, _, err := ln.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("expected empty datagram with descriptor, got %d bytes", n)
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("expected one control message: %v", err)
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("expected one descriptor: %v", err)
+	}
+	file := os.NewFile(uintptr(fds[0]), "entry")
+	defer file.Close()
+	file.Seek(0, 0)
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := parsejournal(t, data); entry["MESSAGE"] != large {
+		t.Fatal("large entry message mismatch")
+	}
+}
+
+func TestJournalFormatterReserved(t *testing.T) {
+
+	f := NewFields()
+	f.set(KeyLogLevel, LevelError)
+	f.set(KeyMessage, "failed")
+	f.Set("priority", "x")
+	f.Set("Message", "y")
+	f.Set("code-func", "z")
+	f.Set("__stack", "w")
+	s := NewJournalFormatter("test").Format(f)
+	for _, name := range []string{"PRIORITY=", "MESSAGE=", "CODE_FUNC=", "STACK="} {
+		if n := strings.Count("\n"+s, "\n"+name); n > 1 {
+			t.Fatalf("%s written %d times:\n%s", name, n, s)
+		}
+	}
+	entry := parsejournal(t, []byte(s))
+	for key, val := range map[string]string{
+		"PRIORITY":         "3",
+		"MESSAGE":          "failed",
+		"CUSTOM_PRIORITY":  "x",
+		"CUSTOM_MESSAGE":   "y",
+		"CUSTOM_CODE_FUNC": "z",
+		"CUSTOM_STACK":     "w",
+	} {
+		if entry[key] != val {
+			t.Fatalf("expected %s=%q, got %q", key, val, entry[key])
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package logex
+
+// JournalWriter is an io.WriteCloser that sends entries to
+// systemd-journald. It is only supported on Linux.
+type JournalWriter struct{}
+
+// DialJournal returns ErrNotSupported on this platform.
+func DialJournal(path string) (*JournalWriter, error) { return nil, ErrNotSupported }
+
+// Write implements io.Writer.
+func (jw *JournalWriter) Write(p []byte) (int, error) { return 0, ErrNotSupported }
+
+// Close closes the JournalWriter.
+func (jw *JournalWriter) Close() error { return ErrNotSupported }
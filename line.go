@@ -5,80 +5,133 @@
 package logex
 
 import (
+	"context"
 	"fmt"
 	"runtime"
-	"sync"
 	"time"
 )
 
 // Line implements the Log interface.
 // It forms a log line from standard properties like timestamp, message, stack
 // and optional user defined values.
+//
+// Line is immutable. Each With* method returns a new Line derived from the
+// receiver that inherits its fields, outputs and level. Derived lines only
+// hold the fields they add and resolve inherited fields through their
+// parents when a line is logged.
 type Line struct {
-	mu      sync.Mutex
-	fields  *Fields
 	log     *Logger
-	cloned  bool
+	parent  *Line
+	fields  *Fields
 	outputs []string
+	lvl     LogLevel
 }
 
 // NewLine returns a new Line instance that will output to Logger l.
 func NewLine(l *Logger) *Line {
 	return &Line{
-		mu:     sync.Mutex{},
 		fields: NewFields(),
 		log:    l,
+		lvl:    LevelPrint,
+	}
+}
+
+// derive returns a new Line whose parent is p.
+func (p *Line) derive() *Line {
+	parent := p
+	if p.fields.Len() == 0 {
+		parent = p.parent
+	}
+	return &Line{
+		log:     p.log,
+		parent:  parent,
+		fields:  NewFields(),
+		outputs: p.outputs,
+		lvl:     p.lvl,
+	}
+}
+
+// collect sets fields of p and its parents to fields, parents first.
+func (p *Line) collect(fields *Fields) {
+	if p.parent != nil {
+		p.parent.collect(fields)
 	}
+	p.fields.Walk(func(key FieldKey, val interface{}) bool {
+		fields.set(key, val)
+		return true
+	})
 }
 
-// flush outputs line fields to the Logger.
-func (p *Line) flush(level LogLevel, message string) {
-	p.fields.set(KeyLogLevel, level)
-	p.fields.set(KeyMessage, message)
-	p.fields.set(KeyTime, time.Now())
-	p.log.print(p.fields, p.outputs...)
+// flush outputs a new line with p fields to the Logger.
+func (p *Line) flush(level LogLevel, err error, message string) {
+	p.emit(time.Now(), level, err, message)
+}
+
+// emit outputs a new line with p fields and timestamp t to the Logger.
+// The time field is not set if t is zero.
+func (p *Line) emit(t time.Time, level LogLevel, err error, message string) {
+	if level > p.lvl {
+		return
+	}
+	fields := getfields()
+	if !t.IsZero() {
+		fields.settime(t)
+	}
+	fields.set(KeyLogLevel, level)
+	fields.setmessage(message)
+	if err != nil {
+		fields.set(KeyError, err)
+	}
+	p.collect(fields)
+	p.log.print(fields, p.outputs...)
 }
 
 // Debugf will log a debug message formed from format string and args.
 func (p *Line) Debugf(format string, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(LevelDebug, fmt.Sprintf(format, args...))
+	if !p.Enabled(LevelDebug) {
+		return
+	}
+	p.flush(LevelDebug, nil, fmt.Sprintf(format, args...))
 }
 
 // Debugln will log args as a debug message.
 func (p *Line) Debugln(args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(LevelDebug, fmt.Sprint(args...)+"\n")
+	if !p.Enabled(LevelDebug) {
+		return
+	}
+	p.flush(LevelDebug, nil, fmt.Sprint(args...)+"\n")
 }
 
 // Infof will log an info message formed from format string and args.
 func (p *Line) Infof(format string, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(LevelInfo, fmt.Sprintf(format, args...))
+	if !p.Enabled(LevelInfo) {
+		return
+	}
+	p.flush(LevelInfo, nil, fmt.Sprintf(format, args...))
 }
 
 // Infoln will log args as an info message.
 func (p *Line) Infoln(args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(LevelInfo, fmt.Sprint(args...)+"\n")
+	if !p.Enabled(LevelInfo) {
+		return
+	}
+	p.flush(LevelInfo, nil, fmt.Sprint(args...)+"\n")
 }
 
 // Warningf will log a warning message formed from format string and args.
 func (p *Line) Warningf(format string, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(LevelWarning, fmt.Sprintf(format, args...))
+	if !p.Enabled(LevelWarning) {
+		return
+	}
+	p.flush(LevelWarning, nil, fmt.Sprintf(format, args...))
 }
 
 // Warningln will log args as a warning message.
 func (p *Line) Warningln(args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(LevelWarning, fmt.Sprint(args...)+"\n")
+	if !p.Enabled(LevelWarning) {
+		return
+	}
+	p.flush(LevelWarning, nil, fmt.Sprint(args...)+"\n")
 }
 
 type errorprinter struct {
@@ -91,105 +144,239 @@ func (ep *errorprinter) Error() string {
 
 // Errorf will log an error and an error message formed from format string and args.
 func (p *Line) Errorf(err error, format string, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	// p.fields.set(KeyError, &errorprinter{err})
-	p.fields.set(KeyError, err)
-	p.flush(LevelError, fmt.Sprintf(format, args...))
+	if !p.Enabled(LevelError) {
+		return
+	}
+	p.flush(LevelError, err, fmt.Sprintf(format, args...))
 }
 
 // Errorln will log an error and args as a warning message.
 func (p *Line) Errorln(err error, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	// p.fields.set(KeyError, &errorprinter{err})
-	p.fields.set(KeyError, err)
-	p.flush(LevelError, fmt.Sprint(args...)+"\n")
+	if !p.Enabled(LevelError) {
+		return
+	}
+	p.flush(LevelError, err, fmt.Sprint(args...)+"\n")
+}
+
+// Tracef will log a trace message formed from format string and args.
+func (p *Line) Tracef(format string, args ...interface{}) {
+	if !p.Enabled(LevelTrace) {
+		return
+	}
+	p.flush(LevelTrace, nil, fmt.Sprintf(format, args...))
+}
+
+// Traceln will log args as a trace message.
+func (p *Line) Traceln(args ...interface{}) {
+	if !p.Enabled(LevelTrace) {
+		return
+	}
+	p.flush(LevelTrace, nil, fmt.Sprint(args...)+"\n")
+}
+
+// Noticef will log a notice message formed from format string and args.
+func (p *Line) Noticef(format string, args ...interface{}) {
+	if !p.Enabled(LevelNotice) {
+		return
+	}
+	p.flush(LevelNotice, nil, fmt.Sprintf(format, args...))
+}
+
+// Noticeln will log args as a notice message.
+func (p *Line) Noticeln(args ...interface{}) {
+	if !p.Enabled(LevelNotice) {
+		return
+	}
+	p.flush(LevelNotice, nil, fmt.Sprint(args...)+"\n")
+}
+
+// Criticalf will log an error and a critical message formed from format string and args.
+func (p *Line) Criticalf(err error, format string, args ...interface{}) {
+	if !p.Enabled(LevelCritical) {
+		return
+	}
+	p.flush(LevelCritical, err, fmt.Sprintf(format, args...))
+}
+
+// Criticalln will log an error and args as a critical message.
+func (p *Line) Criticalln(err error, args ...interface{}) {
+	if !p.Enabled(LevelCritical) {
+		return
+	}
+	p.flush(LevelCritical, err, fmt.Sprint(args...)+"\n")
+}
+
+// Alertf will log an error and an alert message formed from format string and args.
+func (p *Line) Alertf(err error, format string, args ...interface{}) {
+	if !p.Enabled(LevelAlert) {
+		return
+	}
+	p.flush(LevelAlert, err, fmt.Sprintf(format, args...))
+}
+
+// Alertln will log an error and args as an alert message.
+func (p *Line) Alertln(err error, args ...interface{}) {
+	if !p.Enabled(LevelAlert) {
+		return
+	}
+	p.flush(LevelAlert, err, fmt.Sprint(args...)+"\n")
+}
+
+// Emergencyf will log an error and an emergency message formed from format string and args.
+func (p *Line) Emergencyf(err error, format string, args ...interface{}) {
+	if !p.Enabled(LevelEmergency) {
+		return
+	}
+	p.flush(LevelEmergency, err, fmt.Sprintf(format, args...))
+}
+
+// Emergencyln will log an error and args as an emergency message.
+func (p *Line) Emergencyln(err error, args ...interface{}) {
+	if !p.Enabled(LevelEmergency) {
+		return
+	}
+	p.flush(LevelEmergency, err, fmt.Sprint(args...)+"\n")
+}
+
+// Fatalf will log an error and a critical message formed from format string
+// and args, flush all outputs and exit the program with status 1.
+func (p *Line) Fatalf(err error, format string, args ...interface{}) {
+	if p.Enabled(LevelCritical) {
+		p.flush(LevelCritical, err, fmt.Sprintf(format, args...))
+	}
+	p.log.flushall()
+	exit(1)
+}
+
+// Fatalln will log an error and args as a critical message, flush all
+// outputs and exit the program with status 1.
+func (p *Line) Fatalln(err error, args ...interface{}) {
+	if p.Enabled(LevelCritical) {
+		p.flush(LevelCritical, err, fmt.Sprint(args...)+"\n")
+	}
+	p.log.flushall()
+	exit(1)
+}
+
+// Panicf will log an error and a critical message formed from format string
+// and args, flush all outputs and panic with the message.
+func (p *Line) Panicf(err error, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if p.Enabled(LevelCritical) {
+		p.flush(LevelCritical, err, message)
+	}
+	p.log.flushall()
+	panic(message)
+}
+
+// Panicln will log an error and args as a critical message, flush all
+// outputs and panic with the message.
+func (p *Line) Panicln(err error, args ...interface{}) {
+	message := fmt.Sprint(args...)
+	if p.Enabled(LevelCritical) {
+		p.flush(LevelCritical, err, message+"\n")
+	}
+	p.log.flushall()
+	panic(message)
 }
 
 // Printf will log a message with a custom logging level formed from format string and args.
 func (p *Line) Printf(level LogLevel, format string, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(level, fmt.Sprintf(format, args...))
+	if !p.Enabled(level) {
+		return
+	}
+	p.flush(level, nil, fmt.Sprintf(format, args...))
 }
 
 // Println will log args as a message with custom logging level.
 func (p *Line) Println(level LogLevel, args ...interface{}) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	p.flush(level, fmt.Sprint(args...)+"\n")
+	if !p.Enabled(level) {
+		return
+	}
+	p.flush(level, nil, fmt.Sprint(args...)+"\n")
 }
 
-// clone returns a clone of self.
-func (p *Line) clone() *Line {
-	nl := NewLine(p.log)
-	nl.cloned = true
-	p.fields.Walk(func(key FieldKey, val interface{}) bool {
-		nl.fields.set(key, val)
-		return true
-	})
-	return nl
+// Enabled returns true if a line with the specified level would be logged
+// with regard to the level of p and its Logger.
+func (p *Line) Enabled(level LogLevel) bool {
+	return level <= p.lvl && level <= p.log.level()
 }
 
-// lazyclone returns a clone of self if not already cloned.
-func (p *Line) lazyclone() *Line {
-	if p.cloned {
-		return p
+// V returns p if level is enabled or a Log that discards everything
+// otherwise.
+func (p *Line) V(level LogLevel) Log {
+	if !p.Enabled(level) {
+		return noplog{p.log}
 	}
-	return p.clone()
+	return p
 }
 
 // ToOutputs returns a Log clone which outputs to specified named outputs.
 func (p *Line) ToOutputs(names ...string) Log {
-	nl := p.clone()
-	nl.outputs = append(nl.outputs, names...)
-	return nl
+	l := p.derive()
+	l.outputs = append([]string(nil), names...)
+	return l
 }
 
-// WithCaller will append the caller field to the next logged line.
-func (p *Line) WithCaller(skip int) Log {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	l := p.lazyclone()
-	_, file, line, ok := runtime.Caller(skip)
+// WithCaller will append the caller file, line and func fields to the next logged line.
+func (p *Line) WithCaller(skip int) Log { return p.withCaller(skip + 1) }
+
+// withCaller returns a derived Line with the caller file, line and func
+// fields set. skip is passed to runtime.Caller.
+func (p *Line) withCaller(skip int) *Line {
+	l := p.derive()
+	pc, file, line, ok := runtime.Caller(skip)
 	if ok {
 		l.fields.set(KeyFile, file)
 		l.fields.set(KeyLine, line)
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			l.fields.set(KeyFunc, fn.Name())
+		}
 	}
 	return l
 }
 
 // WithStack will append the stack field to the next logged line.
-func (p *Line) WithStack(skip, depth int) Log {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	l := p.lazyclone()
+func (p *Line) WithStack(skip, depth int) Log { return p.withStack(skip+1, depth) }
+
+// withStack returns a derived Line with the stack field set.
+// skip is passed to runtime.Callers.
+func (p *Line) withStack(skip, depth int) *Line {
+	l := p.derive()
 	callers := make([]uintptr, depth)
-	if runtime.Callers(skip, callers) > 0 {
-		frames := runtime.CallersFrames(callers)
-		frameslice := []*Fields{}
-		for i := 0; i < depth; i++ {
-			frame, more := frames.Next()
-			f := NewFields()
-			f.set(KeyFile, frame.File)
-			f.set(KeyLine, frame.Line)
-			f.set(KeyFunc, frame.Func.Name())
-			frameslice = append(frameslice, f)
-			if !more {
-				break
-			}
-		}
-		l.fields.set(KeyFrames, frameslice)
+	if n := runtime.Callers(skip, callers); n > 0 {
+		l.fields.set(KeyFrames, callerframes(callers[:n]))
 	}
 	return l
 }
 
+// callerframes returns frames of callers as Fields with file, line and
+// func fields.
+func callerframes(callers []uintptr) []*Fields {
+	frames := runtime.CallersFrames(callers)
+	frameslice := []*Fields{}
+	for {
+		frame, more := frames.Next()
+		f := NewFields()
+		f.set(KeyFile, frame.File)
+		f.set(KeyLine, frame.Line)
+		f.set(KeyFunc, frame.Func.Name())
+		frameslice = append(frameslice, f)
+		if !more {
+			break
+		}
+	}
+	return frameslice
+}
+
 // WithFields will append the specified fields to the next logged line.
-func (p *Line) WithFields(fields *Fields) Log {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	l := p.lazyclone()
+func (p *Line) WithFields(fields *Fields) Log { return p.With(fields) }
+
+// With returns a Log that appends the specified fields to every line it logs.
+// Fields are copied so modifying fields afterwards has no effect on the
+// returned Log.
+func (p *Line) With(fields *Fields) Log {
+	l := p.derive()
 	fields.Walk(func(key FieldKey, val interface{}) bool {
 		if err, ok := val.(error); ok {
 			l.fields.Set(key, &errorprinter{err})
@@ -200,3 +387,108 @@ func (p *Line) WithFields(fields *Fields) Log {
 	})
 	return l
 }
+
+// WithLevel returns a Log that discards lines with a logging level above
+// level in addition to any level restrictions of the Logger.
+func (p *Line) WithLevel(level LogLevel) Log {
+	l := p.derive()
+	l.lvl = level
+	return l
+}
+
+// withcontext returns a Line derived from p with fields extracted from ctx
+// by Logger's context extractors or p if there are none.
+func (p *Line) withcontext(ctx context.Context) *Line {
+	extractors := p.log.contextextractors()
+	if ctx == nil || len(extractors) == 0 {
+		return p
+	}
+	l := p.derive()
+	for _, ce := range extractors {
+		ce(ctx, l.fields)
+	}
+	return l
+}
+
+// WithContext returns a Log that appends fields extracted from ctx to every line it logs.
+func (p *Line) WithContext(ctx context.Context) Log { return p.withcontext(ctx) }
+
+// DebugfCtx will log a debug message formed from format string and args with fields extracted from ctx.
+func (p *Line) DebugfCtx(ctx context.Context, format string, args ...interface{}) {
+	if !p.Enabled(LevelDebug) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelDebug, nil, fmt.Sprintf(format, args...))
+}
+
+// DebuglnCtx will log args as a debug message with fields extracted from ctx.
+func (p *Line) DebuglnCtx(ctx context.Context, args ...interface{}) {
+	if !p.Enabled(LevelDebug) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelDebug, nil, fmt.Sprint(args...)+"\n")
+}
+
+// InfofCtx will log an info message formed from format string and args with fields extracted from ctx.
+func (p *Line) InfofCtx(ctx context.Context, format string, args ...interface{}) {
+	if !p.Enabled(LevelInfo) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelInfo, nil, fmt.Sprintf(format, args...))
+}
+
+// InfolnCtx will log args as an info message with fields extracted from ctx.
+func (p *Line) InfolnCtx(ctx context.Context, args ...interface{}) {
+	if !p.Enabled(LevelInfo) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelInfo, nil, fmt.Sprint(args...)+"\n")
+}
+
+// WarningfCtx will log a warning message formed from format string and args with fields extracted from ctx.
+func (p *Line) WarningfCtx(ctx context.Context, format string, args ...interface{}) {
+	if !p.Enabled(LevelWarning) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelWarning, nil, fmt.Sprintf(format, args...))
+}
+
+// WarninglnCtx will log args as a warning message with fields extracted from ctx.
+func (p *Line) WarninglnCtx(ctx context.Context, args ...interface{}) {
+	if !p.Enabled(LevelWarning) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelWarning, nil, fmt.Sprint(args...)+"\n")
+}
+
+// ErrorfCtx will log an error and an error message formed from format string and args with fields extracted from ctx.
+func (p *Line) ErrorfCtx(ctx context.Context, err error, format string, args ...interface{}) {
+	if !p.Enabled(LevelError) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelError, err, fmt.Sprintf(format, args...))
+}
+
+// ErrorlnCtx will log an error and args as a warning message with fields extracted from ctx.
+func (p *Line) ErrorlnCtx(ctx context.Context, err error, args ...interface{}) {
+	if !p.Enabled(LevelError) {
+		return
+	}
+	p.withcontext(ctx).flush(LevelError, err, fmt.Sprint(args...)+"\n")
+}
+
+// PrintfCtx will log a message with a custom logging level formed from format string and args with fields extracted from ctx.
+func (p *Line) PrintfCtx(ctx context.Context, level LogLevel, format string, args ...interface{}) {
+	if !p.Enabled(level) {
+		return
+	}
+	p.withcontext(ctx).flush(level, nil, fmt.Sprintf(format, args...))
+}
+
+// PrintlnCtx will log args as a message with custom logging level with fields extracted from ctx.
+func (p *Line) PrintlnCtx(ctx context.Context, level LogLevel, args ...interface{}) {
+	if !p.Enabled(level) {
+		return
+	}
+	p.withcontext(ctx).flush(level, nil, fmt.Sprint(args...)+"\n")
+}
@@ -6,6 +6,8 @@
 package logex
 
 import (
+	"context"
+
 	"github.com/vedranvuk/errorex"
 )
 
@@ -29,20 +31,83 @@ type Log interface {
 	// Errorln will log an error and args as a warning message.
 	Errorln(error, ...interface{})
 
+	// Tracef will log a trace message formed from format string and args.
+	Tracef(string, ...interface{})
+	// Traceln will log args as a trace message.
+	Traceln(...interface{})
+	// Noticef will log a notice message formed from format string and args.
+	Noticef(string, ...interface{})
+	// Noticeln will log args as a notice message.
+	Noticeln(...interface{})
+	// Criticalf will log an error and a critical message formed from format string and args.
+	Criticalf(error, string, ...interface{})
+	// Criticalln will log an error and args as a critical message.
+	Criticalln(error, ...interface{})
+	// Alertf will log an error and an alert message formed from format string and args.
+	Alertf(error, string, ...interface{})
+	// Alertln will log an error and args as an alert message.
+	Alertln(error, ...interface{})
+	// Emergencyf will log an error and an emergency message formed from format string and args.
+	Emergencyf(error, string, ...interface{})
+	// Emergencyln will log an error and args as an emergency message.
+	Emergencyln(error, ...interface{})
+
+	// Fatalf will log a critical message like Criticalf, flush all outputs and exit the program with status 1.
+	Fatalf(error, string, ...interface{})
+	// Fatalln will log a critical message like Criticalln, flush all outputs and exit the program with status 1.
+	Fatalln(error, ...interface{})
+	// Panicf will log a critical message like Criticalf, flush all outputs and panic with the message.
+	Panicf(error, string, ...interface{})
+	// Panicln will log a critical message like Criticalln, flush all outputs and panic with the message.
+	Panicln(error, ...interface{})
+
 	// Printf will log a message with a custom logging level formed from format string and args.
 	Printf(LogLevel, string, ...interface{})
 	// Println will log args as a message with custom logging level.
 	Println(LogLevel, ...interface{})
 
+	// Enabled will return true if a line with the specified level would be logged.
+	Enabled(LogLevel) bool
+	// V will return the Log if level is enabled or a Log that discards everything otherwise.
+	V(LogLevel) Log
+
 	// ToOutputs will return a clone which will output only to specified output names.
 	ToOutputs(names ...string) Log
 
-	// Caller will append the caller field to the next logged line.
+	// Caller will append the caller file, line and func fields to the next logged line.
 	WithCaller(skip int) Log
 	// Stack will append the stack field to the next logged line.
 	WithStack(skip int, depth int) Log
 	// Fields will append the specified fields to the next logged line.
 	WithFields(*Fields) Log
+
+	// With will return a derived Log that appends fields to every logged line.
+	With(*Fields) Log
+	// WithLevel will return a derived Log that discards lines above level.
+	WithLevel(LogLevel) Log
+	// WithContext will return a derived Log that appends fields extracted from ctx to every logged line.
+	WithContext(context.Context) Log
+
+	// DebugfCtx will log a debug message formed from format string and args with fields extracted from ctx.
+	DebugfCtx(context.Context, string, ...interface{})
+	// DebuglnCtx will log args as a debug message with fields extracted from ctx.
+	DebuglnCtx(context.Context, ...interface{})
+	// InfofCtx will log an info message formed from format string and args with fields extracted from ctx.
+	InfofCtx(context.Context, string, ...interface{})
+	// InfolnCtx will log args as an info message with fields extracted from ctx.
+	InfolnCtx(context.Context, ...interface{})
+	// WarningfCtx will log a warning message formed from format string and args with fields extracted from ctx.
+	WarningfCtx(context.Context, string, ...interface{})
+	// WarninglnCtx will log args as a warning message with fields extracted from ctx.
+	WarninglnCtx(context.Context, ...interface{})
+	// ErrorfCtx will log an error and an error message formed from format string and args with fields extracted from ctx.
+	ErrorfCtx(context.Context, error, string, ...interface{})
+	// ErrorlnCtx will log an error and args as a warning message with fields extracted from ctx.
+	ErrorlnCtx(context.Context, error, ...interface{})
+	// PrintfCtx will log a message with a custom logging level formed from format string and args with fields extracted from ctx.
+	PrintfCtx(context.Context, LogLevel, string, ...interface{})
+	// PrintlnCtx will log args as a message with custom logging level with fields extracted from ctx.
+	PrintlnCtx(context.Context, LogLevel, ...interface{})
 }
 
 var (
@@ -50,6 +115,10 @@ var (
 	ErrLogex = errorex.New("logex")
 	// ErrUnmarshalLevel is returned when unmarshaling an invalid value from text as LogLevel.
 	ErrUnmarshalLevel = ErrLogex.WrapFormat("error unmarshaling '%s' as loglevel")
+	// ErrInvalidLevel is returned when registering a level with an invalid value, name or options.
+	ErrInvalidLevel = ErrLogex.WrapFormat("invalid level %d '%s'")
+	// ErrDuplicateLevel is returned when registering a level whose value or name is already in use.
+	ErrDuplicateLevel = ErrLogex.WrapFormat("duplicate level %d '%s'")
 	// ErrReservedKey is returned when a reserved key is being set to Fields.
 	ErrReservedKey = ErrLogex.WrapFormat("cannot set field '%s', key is reserved")
 	// ErrInvalidWalkFunc is returned when an invalid func was passed to Fields.Walk().
@@ -58,4 +127,16 @@ var (
 	ErrInvalidName = ErrLogex.WrapFormat("invalid output name")
 	// ErrDuplicateName is returned when a duplicate output name was specified.
 	ErrDuplicateName = ErrLogex.WrapFormat("duplicate name '%s'")
+	// ErrOutputNotFound is returned when an output with specified name is not found.
+	ErrOutputNotFound = ErrLogex.WrapFormat("output '%s' not found")
+	// ErrClosed is returned when writing to a closed output.
+	ErrClosed = ErrLogex.Wrap("output closed")
+	// ErrNotSupported is returned when a feature is not supported on the platform.
+	ErrNotSupported = ErrLogex.Wrap("not supported on this platform")
+	// ErrInvalidTraceparent is returned when parsing an invalid traceparent header.
+	ErrInvalidTraceparent = ErrLogex.WrapFormat("invalid traceparent '%s'")
+	// ErrExport is returned when exporting lines to a remote collector fails.
+	ErrExport = ErrLogex.WrapFormat("export failed: %s")
+	// ErrWriteTimeout is reported when a write to an output times out.
+	ErrWriteTimeout = ErrLogex.WrapFormat("write to output '%s' timed out")
 )
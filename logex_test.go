@@ -5,17 +5,37 @@
 package logex
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/slogtest"
+	"time"
 )
 
 func BenchmarkLogEmpty(b *testing.B) {
 	b.StopTimer()
 	l := New(nil)
 	l.SetLevel(LevelPrint)
+	b.ReportAllocs()
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		l.Println(42)
@@ -31,6 +51,7 @@ func BenchmarkLogSimple(b *testing.B) {
 	l := New(nil)
 	l.AddOutput("out", &fakewriter{}, NewSimpleFormatter())
 	l.SetLevel(LevelPrint)
+	b.ReportAllocs()
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		l.Println(42)
@@ -42,12 +63,68 @@ func BenchmarkLogJSON(b *testing.B) {
 	l := New(nil)
 	l.AddOutput("out", &fakewriter{}, NewJSONFormatter(true))
 	l.SetLevel(LevelPrint)
+	b.ReportAllocs()
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
 		l.Println(42)
 	}
 }
 
+func BenchmarkLogDisabled(b *testing.B) {
+	b.StopTimer()
+	l := New(nil)
+	l.AddOutput("out", &fakewriter{}, NewJSONFormatter(false))
+	l.SetLevel(LevelInfo)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		l.Debugf("disabled")
+	}
+}
+
+func BenchmarkLogDisabledV(b *testing.B) {
+	b.StopTimer()
+	l := New(nil)
+	l.AddOutput("out", &fakewriter{}, NewJSONFormatter(false))
+	l.SetLevel(LevelInfo)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		l.V(LevelDebug).Debugf("disabled")
+	}
+}
+
+func benchmarkAppendFormat(b *testing.B, af AppendFormatter) {
+	b.StopTimer()
+	f := NewFields()
+	f.set(KeyTime, time.Now())
+	f.set(KeyLogLevel, LevelInfo)
+	f.set(KeyMessage, "request served")
+	f.Set("method", "GET")
+	f.Set("path", "/api/v1/users")
+	f.Set("status", 200)
+	f.Set("duration", 1.25)
+	f.Set("cached", true)
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		buf = af.AppendFormat(buf[:0], f)
+	}
+}
+
+func BenchmarkAppendSimple(b *testing.B) {
+	benchmarkAppendFormat(b, NewAppendFormatter(NewSimpleFormatter()))
+}
+
+func BenchmarkAppendJSON(b *testing.B) {
+	benchmarkAppendFormat(b, NewAppendFormatter(NewJSONFormatter(false)))
+}
+
+func BenchmarkAppendLogfmt(b *testing.B) {
+	benchmarkAppendFormat(b, NewAppendFormatter(NewLogfmtFormatter()))
+}
+
 func TestConcurrent(t *testing.T) {
 
 	defer func() {
@@ -122,3 +199,1523 @@ func TestOutputs(t *testing.T) {
 	sub := l.ToOutputs("2", "4")
 	sub.Println(LevelDebug, "test")
 }
+
+func TestWith(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+	l.AddOutput("other", ioutil.Discard, NewSimpleFormatter())
+
+	f := NewFields()
+	f.Set("component", "db")
+	parent := l.With(f).ToOutputs("json").WithLevel(LevelInfo)
+	f.Set("component", "changed")
+
+	cf := NewFields()
+	cf.Set("request", 42)
+	child := parent.With(cf)
+
+	parent.Errorln(errors.New("failed"), "first")
+	parent.Infoln("second")
+	parent.Debugln("discarded")
+	child.Infoln("third")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d", len(lines))
+	}
+	for i, line := range lines {
+		if !strings.Contains(line, `"component":"db"`) {
+			t.Fatalf("line %d missing bound field: %s", i, line)
+		}
+	}
+	if strings.Contains(lines[1], `"error"`) {
+		t.Fatalf("error leaked into next line: %s", lines[1])
+	}
+	if strings.Contains(lines[1], `"request"`) || !strings.Contains(lines[2], `"request":42`) {
+		t.Fatal("child fields not isolated from parent")
+	}
+}
+
+func TestConcurrentWith(t *testing.T) {
+
+	const threads, lines = 8, 200
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(threadid int) {
+			defer wg.Done()
+			for i := 0; i < lines; i++ {
+				f := NewFields()
+				f.Set("thread", threadid)
+				l.WithCaller(1).WithFields(f).Infof("%d", threadid)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var v struct {
+			Thread  int    `json:"thread"`
+			Message string `json:"message"`
+		}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(v.Thread) != v.Message {
+			t.Fatalf("crossed fields: %s", line)
+		}
+		count++
+	}
+	if count != threads*lines {
+		t.Fatalf("expected %d lines, got %d", threads*lines, count)
+	}
+}
+
+type blockingwriter struct {
+	mu      sync.Mutex
+	release chan struct{}
+	lines   int
+}
+
+func (bw *blockingwriter) Write(p []byte) (int, error) {
+	<-bw.release
+	bw.mu.Lock()
+	bw.lines++
+	bw.mu.Unlock()
+	return len(p), nil
+}
+
+func TestAsync(t *testing.T) {
+
+	const total = 10
+
+	bw := &blockingwriter{release: make(chan struct{})}
+	l := New(nil)
+	l.AddAsyncOutput("slow", bw, NewSimpleFormatter(), &AsyncOptions{Size: 2, Policy: OverflowDropNewest})
+	for i := 0; i < total; i++ {
+		l.Infoln(i)
+	}
+	close(bw.release)
+	if err := l.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if l.Dropped() == 0 {
+		t.Fatal("expected dropped lines")
+	}
+	if uint64(bw.lines)+l.Dropped() != total {
+		t.Fatalf("written %d + dropped %d != %d", bw.lines, l.Dropped(), total)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	l = New(nil)
+	l.AddOutput("buf", buf, NewSimpleFormatter())
+	l.SetAsync(&AsyncOptions{Size: 1, Policy: OverflowBlock})
+	for i := 0; i < total; i++ {
+		l.Infoln(i)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	l.Infoln("sync")
+	if n := strings.Count(buf.String(), "\n"); n != total+1 {
+		t.Fatalf("expected %d lines, got %d", total+1, n)
+	}
+
+	// Lines logged while Close drains an output queue are written after
+	// the queued lines and never concurrently with them.
+	ow := &orderwriter{}
+	l = New(nil)
+	l.AddAsyncOutput("ordered", ow, NewLogfmtFormatter(), &AsyncOptions{Size: 100})
+	for i := 0; i < total; i++ {
+		l.Infof("%d", i)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := total; i < 3*total; i++ {
+			l.Infof("%d", i)
+		}
+	}()
+	l.Close()
+	<-done
+	if len(ow.lines) != 3*total {
+		t.Fatalf("expected %d lines, got %d", 3*total, len(ow.lines))
+	}
+	for i, line := range ow.lines {
+		if !strings.Contains(line, fmt.Sprintf("msg=%d\n", i)) {
+			t.Fatalf("line %d out of order: %q", i, line)
+		}
+	}
+}
+
+// orderwriter records lines and is not safe for concurrent use.
+type orderwriter struct {
+	lines []string
+}
+
+func (ow *orderwriter) Write(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	ow.lines = append(ow.lines, string(p))
+	return len(p), nil
+}
+
+func TestOutputOptions(t *testing.T) {
+
+	file := bytes.NewBuffer(nil)
+	console := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutputOptions("file", file, NewSimpleFormatter(), OutputOptions{})
+	l.AddOutputOptions("console", console, NewSimpleFormatter(), OutputOptions{
+		MaxLevel: LevelWarning,
+	})
+
+	l.Debugln("debug")
+	l.Warningln("warning")
+	l.Errorln(errors.New("error"), "error")
+	if !strings.Contains(file.String(), "debug") {
+		t.Fatalf("file: debug line missing: %s", file.String())
+	}
+	if strings.Contains(console.String(), "debug") || !strings.Contains(console.String(), "warning") {
+		t.Fatalf("console: level limit not applied: %s", console.String())
+	}
+
+	if err := l.SetOutputOptions("console", OutputOptions{
+		Filter: func(f *Fields) bool { return f.Message() == "kept\n" },
+	}); err != nil {
+		t.Fatal(err)
+	}
+	console.Reset()
+	l.Debugln("kept")
+	l.Debugln("filtered")
+	if console.String() == "" || strings.Contains(console.String(), "filtered") {
+		t.Fatalf("console: filter not applied: %s", console.String())
+	}
+	if err := l.SetOutputOptions("none", OutputOptions{}); err == nil {
+		t.Fatal("expected error for unknown output")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "logex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "app.log")
+	rf, err := NewRotatingFile(filename, RotatingFileOptions{
+		MaxSize:    64,
+		MaxBackups: 2,
+		Compress:   true,
+		Interval:   RotateHourly,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(nil)
+	l.AddOutput("file", rf, NewJSONFormatter(false))
+	for i := 0; i < 10; i++ {
+		l.Infoln(i)
+	}
+
+	rf.mu.Lock()
+	rf.rotateAt = time.Now()
+	rf.mu.Unlock()
+	l.Infoln("next hour")
+
+	if err := rf.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "next hour") {
+		t.Fatalf("expected time rotation, got: %s", data)
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 compressed backups, got %v", backups)
+	}
+	if plain, _ := filepath.Glob(filepath.Join(dir, "app-*.log")); len(plain) != 0 {
+		t.Fatalf("uncompressed backups left: %v", plain)
+	}
+
+	// A backup name longer than the file system allows fails the rename.
+	filename = filepath.Join(dir, strings.Repeat("x", 240)+".log")
+	rf, err = NewRotatingFile(filename, RotatingFileOptions{MaxSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	for i := 0; i < 3; i++ {
+		if n, err := rf.Write([]byte("line of sixteen\n")); n != 16 || (i > 0) != (err != nil) {
+			t.Fatalf("write %d: %d %v", i, n, err)
+		}
+	}
+	if err := rf.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rf.Write([]byte("after reopen\n")); err == nil {
+		t.Fatal("expected rotation error")
+	}
+	data, err = ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(data), "line of sixteen\n") != 3 || !strings.HasSuffix(string(data), "after reopen\n") {
+		t.Fatalf("lines lost after failed rotation: %q", data)
+	}
+}
+
+func TestLogfmtFormatter(t *testing.T) {
+
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, LevelError)
+	f.set(KeyMessage, "request \"failed\"\n")
+	f.set(KeyError, errors.New("connection refused"))
+	f.set(KeyFile, "main.go")
+	f.set(KeyLine, 42)
+	f.Set("user", "john")
+	f.Set("attempt", 3)
+	f.Set("bad key", "a=b")
+
+	expected := `time=2020-03-03T13:00:00Z level=error msg="request \"failed\"" ` +
+		`error="connection refused" caller=main.go:42 user=john attempt=3 bad_key="a=b"` + "\n"
+	if s := NewLogfmtFormatter().Format(f); s != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+	f.SetOrder(OrderSorted)
+	expected = `time=2020-03-03T13:00:00Z level=error msg="request \"failed\"" ` +
+		`error="connection refused" caller=main.go:42 attempt=3 bad_key="a=b" user=john` + "\n"
+	if s := NewLogfmtFormatter().Format(f); s != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestConsoleFormatter(t *testing.T) {
+
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, LevelInfo)
+	f.set(KeyMessage, "started\n")
+	f.set(KeyFile, "main.go")
+	f.set(KeyLine, 42)
+	f.Set("port", 8080)
+
+	cf := NewConsoleFormatter(bytes.NewBuffer(nil))
+	if cf.Colors {
+		t.Fatal("colors enabled for non-terminal writer")
+	}
+	expected := "13:00:00.000 INFO      started  port=8080\n    caller: main.go:42\n"
+	if s := cf.Format(f); s != expected {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, s)
+	}
+
+	cf.Colors = true
+	cf.Palette = map[LogLevel]Color{LevelInfo: ColorMagenta}
+	if s := cf.Format(f); !strings.Contains(s, string(ColorMagenta)+"INFO"+colorReset) {
+		t.Fatalf("palette color not applied: %q", s)
+	}
+
+	cf.Colors = false
+	f.set(KeyError, fmt.Errorf("connect: %w", errors.New("refused")))
+	expected = "13:00:00.000 INFO      started  port=8080\n" +
+		"    error: connect: refused\n" +
+		"        caused by: refused\n" +
+		"    caller: main.go:42\n"
+	if s := cf.Format(f); s != expected {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, s)
+	}
+}
+
+func TestTemplateFormatter(t *testing.T) {
+
+	tf, err := NewTemplateFormatter(`{{.Time | time "2006-01-02 15:04"}} [{{.LogLevel | upper | padright 7}}] ` +
+		`{{.Message | truncate 5}} user={{.Fields.user | json}}{{if .Error}} err={{.Error | json}}{{end}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, LevelError)
+	f.set(KeyMessage, "request failed\n")
+	f.set(KeyError, errors.New("refused"))
+	f.Set("user", "john")
+
+	expected := `2020-03-03 13:00 [ERROR  ] reque user="john" err="refused"` + "\n"
+	if s := tf.Format(f); s != expected {
+		t.Fatalf("expected:\n%q\ngot:\n%q", expected, s)
+	}
+}
+
+func TestSyslogFormatter(t *testing.T) {
+
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, LevelWarning)
+	f.set(KeyMessage, "disk almost full\n")
+	f.Set("path", `/var/"data"]`)
+
+	opts := SyslogOptions{
+		Facility: FacilityLocal0,
+		Hostname: "host",
+		AppName:  "app",
+		ProcID:   "42",
+		MsgID:    "DISK",
+	}
+	expected := `<132>1 2020-03-03T13:00:00.000000Z host app 42 DISK [logex@32473 path="/var/\"data\"\]"] disk almost full`
+	if s := NewSyslogFormatter(opts).Format(f); s != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+
+	opts.Format = SyslogRFC3164
+	expected = `<132>Mar  3 13:00:00 host app[42]: disk almost full path="/var/\"data\"]"`
+	if s := NewSyslogFormatter(opts).Format(f); s != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+}
+
+func TestSyslogWriter(t *testing.T) {
+
+	opts := SyslogOptions{Hostname: "host", AppName: "app", ProcID: "1"}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	sw, err := DialSyslog("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := New(nil)
+	l.AddOutput("syslog", sw, NewSyslogFormatter(opts))
+	l.Infoln("over udp")
+	buf := make([]byte, 1024)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(buf[:n]); !strings.HasPrefix(s, "<14>1 ") || !strings.HasSuffix(s, " over udp") {
+		t.Fatalf("unexpected udp message: %q", s)
+	}
+	sw.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	sw, err = DialSyslog("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	l = New(nil)
+	l.AddOutput("syslog", sw, NewSyslogFormatter(opts))
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Infoln("first")
+	r := bufio.NewReader(conn)
+	readframe := func(r *bufio.Reader) string {
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		return string(msg)
+	}
+	if s := readframe(r); !strings.HasSuffix(s, " first") {
+		t.Fatalf("unexpected tcp message: %q", s)
+	}
+
+	// Drop the connection and expect the writer to reconnect.
+	conn.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	var reconn net.Conn
+	for i := 0; reconn == nil && i < 100; i++ {
+		l.Infoln("again")
+		select {
+		case reconn = <-accepted:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if reconn == nil {
+		t.Fatal("writer did not reconnect")
+	}
+	defer reconn.Close()
+	if s := readframe(bufio.NewReader(reconn)); !strings.HasSuffix(s, " again") {
+		t.Fatalf("unexpected tcp message: %q", s)
+	}
+
+	ul, err := net.Listen("unix", filepath.Join(t.TempDir(), "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	sw, err = DialSyslog("unix", ul.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sw.Close()
+	l = New(nil)
+	l.AddOutput("syslog", sw, NewSyslogFormatter(opts))
+
+	uconn, err := ul.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uconn.Close()
+	l.Infoln("one")
+	l.Infoln("two")
+	r = bufio.NewReader(uconn)
+	for _, want := range []string{" one\n", " two\n"} {
+		if s, err := r.ReadString('\n'); err != nil || !strings.HasSuffix(s, want) {
+			t.Fatalf("unexpected unix message: %q, %v", s, err)
+		}
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.SetLevel(LevelInfo)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+
+	sl := slog.New(NewSlogHandler(l, &SlogHandlerOptions{AddSource: true}))
+	if sl.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("debug enabled above logger level")
+	}
+	sl.Debug("discarded")
+	sl.With("component", "db").WithGroup("req").Warn("slow", "ms", 250, slog.Group("user", "id", 7))
+	sl.Error("failed", "error", errors.New("refused"), "message", "shadowed")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &v); err != nil {
+		t.Fatal(err)
+	}
+	for key, val := range map[string]interface{}{
+		"component":   "db",
+		"req.ms":      float64(250),
+		"req.user.id": float64(7),
+		"loglevel":    "Warning",
+		"message":     "slow",
+	} {
+		if v[key] != val {
+			t.Fatalf("expected %s=%v, got %v", key, val, v[key])
+		}
+	}
+	if file, _ := v["file"].(string); !strings.HasSuffix(file, "logex_test.go") {
+		t.Fatalf("missing source: %v", v)
+	}
+	if !strings.Contains(lines[1], `"_message":"shadowed"`) || !strings.Contains(lines[1], `"error":{"message":"refused"`) {
+		t.Fatalf("unexpected error line: %s", lines[1])
+	}
+	for level, expected := range map[slog.Level]LogLevel{
+		slog.LevelError + 12: LevelEmergency,
+		slog.LevelError + 8:  LevelAlert,
+		slog.LevelError + 4:  LevelCritical,
+		slog.LevelError:      LevelError,
+		slog.LevelWarn:       LevelWarning,
+		slog.LevelInfo + 2:   LevelNotice,
+		slog.LevelInfo:       LevelInfo,
+		slog.LevelDebug:      LevelDebug,
+		slog.LevelDebug - 4:  LevelTrace,
+		slog.LevelDebug - 5:  LevelCustom,
+	} {
+		if lvl := LevelFromSlog(level); lvl != expected {
+			t.Fatalf("%v mapped to %v, expected %v", level, lvl, expected)
+		}
+	}
+}
+
+func TestSlogHandlerConformance(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.SetLevel(LevelInfo)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+
+	results := func() []map[string]interface{} {
+		var ms []map[string]interface{}
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			var v map[string]interface{}
+			if err := json.Unmarshal(line, &v); err != nil {
+				t.Fatal(err)
+			}
+			m := map[string]interface{}{}
+			for key, val := range v {
+				switch FieldKey(key) {
+				case KeyTime:
+					key = slog.TimeKey
+				case KeyLogLevel:
+					key = slog.LevelKey
+				case KeyMessage:
+					key = slog.MessageKey
+				}
+				// Group keys are dot separated.
+				groups := strings.Split(key, ".")
+				g := m
+				for _, name := range groups[:len(groups)-1] {
+					if _, ok := g[name].(map[string]interface{}); !ok {
+						g[name] = map[string]interface{}{}
+					}
+					g = g[name].(map[string]interface{})
+				}
+				g[groups[len(groups)-1]] = val
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+	if err := slogtest.TestHandler(NewSlogHandler(l, nil), results); err != nil {
+		t.Fatal(err)
+	}
+
+	type ctxkey struct{}
+	buf.Reset()
+	l.AddContextExtractor(ContextValueExtractor(ctxkey{}, "tenant"))
+	slog.New(SlogHandler(l)).InfoContext(context.WithValue(context.Background(), ctxkey{}, "acme"), "extracted")
+	if !strings.Contains(buf.String(), `"tenant":"acme"`) {
+		t.Fatalf("context fields not extracted: %s", buf.String())
+	}
+}
+
+func TestStdLog(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+
+	l.StdLogger(LevelWarning).Printf("plain %d", 1)
+
+	sl := log.New(NewStdWriter(l, LevelError, "app: ", log.Ldate|log.Ltime|log.Lshortfile|log.LUTC), "app: ",
+		log.Ldate|log.Ltime|log.Lshortfile|log.LUTC)
+	sl.Println("with header")
+
+	restore := RedirectStdLog(l, LevelInfo)
+	log.Print("redirected")
+	restore()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d: %s", len(lines), buf.String())
+	}
+	var v struct {
+		File     string    `json:"file"`
+		Line     int       `json:"line"`
+		LogLevel LogLevel  `json:"loglevel"`
+		Message  string    `json:"message"`
+		Time     time.Time `json:"time"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Message != "plain 1" || v.LogLevel != LevelWarning {
+		t.Fatalf("unexpected line: %s", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Message != "with header" || v.File != "logex_test.go" || v.Line == 0 || v.Time.Nanosecond() != 0 {
+		t.Fatalf("header not parsed: %s", lines[1])
+	}
+	if !strings.Contains(lines[2], `"message":"redirected"`) {
+		t.Fatalf("unexpected line: %s", lines[2])
+	}
+}
+
+func TestContext(t *testing.T) {
+
+	type requestid struct{}
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+	l.AddContextExtractor(ContextValueExtractor(requestid{}, "request_id"))
+	l.AddContextExtractor(func(ctx context.Context, fields *Fields) {
+		fields.Set("tenant", "acme")
+	})
+
+	f := NewFields()
+	f.Set("component", "api")
+	ctx := NewContext(context.WithValue(context.Background(), requestid{}, "r1"), l.With(f))
+	FromContext(ctx).InfofCtx(ctx, "handled")
+	l.Infof("no context")
+
+	if FromContext(context.Background()) != Log(logger) {
+		t.Fatal("expected default logger")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(lines))
+	}
+	for _, s := range []string{`"request_id":"r1"`, `"tenant":"acme"`, `"component":"api"`} {
+		if !strings.Contains(lines[0], s) {
+			t.Fatalf("missing %s: %s", s, lines[0])
+		}
+	}
+	if strings.Contains(lines[1], "request_id") {
+		t.Fatalf("context fields leaked: %s", lines[1])
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	tc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tc.Sampled() || tc.String() != header {
+		t.Fatalf("unexpected trace context: %s", tc)
+	}
+	for _, s := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Fatalf("expected error for '%s'", s)
+		}
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+
+	var mu sync.Mutex
+	requests := 0
+	var body map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	oe := NewOTLPExporter(OTLPOptions{
+		Endpoint:      srv.URL,
+		Resource:      map[string]interface{}{"service.name": "test"},
+		FlushInterval: time.Hour,
+		RetryBackoff:  time.Millisecond,
+	})
+	l := New(nil)
+	l.AddOutput("otlp", oe, NewOTLPFormatter())
+	l.AddContextExtractor(TraceExtractor())
+
+	tc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithTrace(context.Background(), tc)
+	f := NewFields()
+	f.Set("user", "john")
+	l.WithFields(f).ErrorfCtx(ctx, errors.New("refused"), "request failed")
+	l.Infoln("second")
+
+	if err := oe.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected a retry, got %d requests", requests)
+	}
+	data, _ := json.Marshal(body)
+	for _, s := range []string{
+		`"service.name"`,
+		`"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+		`"spanId":"00f067aa0ba902b7"`,
+		`"flags":1`,
+		`"severityNumber":17`,
+		`"severityText":"ERROR"`,
+		`{"key":"exception.message","value":{"stringValue":"refused"}}`,
+		`{"key":"user","value":{"stringValue":"john"}}`,
+		`"body":{"stringValue":"second"}`,
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Fatalf("missing %s in %s", s, data)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+	l.SetSampling(&SamplingOptions{
+		Window: time.Hour,
+		Levels: map[LogLevel]SamplingBudget{
+			LevelError: {First: 3, Thereafter: 10},
+		},
+	})
+
+	for i := 0; i < 25; i++ {
+		l.Errorf(errors.New("upstream"), "request failed")
+	}
+	for i := 0; i < 5; i++ {
+		l.Infof("not sampled")
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	// 3 first, 13th and 23rd error lines and all info lines.
+	if len(lines) != 10 {
+		t.Fatalf("expected 10 lines, got %d", len(lines))
+	}
+	if strings.Contains(lines[2], `"sampled"`) {
+		t.Fatalf("initial line marked as sampled: %s", lines[2])
+	}
+	if !strings.Contains(lines[3], `"dropped":9`) || !strings.Contains(lines[3], `"sampled":true`) {
+		t.Fatalf("expected dropped count: %s", lines[3])
+	}
+
+	s := newsampler(&SamplingOptions{Window: time.Second, Default: SamplingBudget{First: 1}})
+	line := func(t time.Time, message string) *Fields {
+		f := NewFields()
+		f.set(KeyTime, t)
+		f.set(KeyLogLevel, LevelInfo)
+		f.set(KeyMessage, message)
+		return f
+	}
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		s.sample(line(start, "noisy"))
+	}
+	var summaries []*Fields
+	for i := 0; i < 1000; i++ {
+		_, expired := s.sample(line(start.Add(2*time.Second), "unique "+strconv.Itoa(i)))
+		summaries = append(summaries, expired...)
+	}
+	if len(summaries) != 1 || summaries[0].Message() != "noisy" {
+		t.Fatalf("expected one summary of dropped lines, got %d", len(summaries))
+	}
+	if n, _ := summaries[0].Get(KeyDropped); n != 4 {
+		t.Fatalf("expected 4 dropped lines, got %v", n)
+	}
+	for i := range s.shards {
+		for _, c := range s.shards[i].counters {
+			if c.message == "noisy" {
+				t.Fatal("expired counter not evicted")
+			}
+		}
+	}
+
+	// Records without a time are sampled in windows of the current time.
+	buf.Reset()
+	l.SetSampling(&SamplingOptions{Window: 10 * time.Millisecond, Default: SamplingBudget{First: 1}})
+	h := SlogHandler(l)
+	for i := 0; i < 3; i++ {
+		if i == 2 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err := h.Handle(context.Background(), slog.NewRecord(time.Time{}, slog.LevelInfo, "untimed", 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(buf.String(), `"message":"untimed"`); n != 2 {
+		t.Fatalf("expected 2 untimed lines, got %d: %s", n, buf.String())
+	}
+}
+
+func TestDedup(t *testing.T) {
+
+	console := bytes.NewBuffer(nil)
+	archive := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutputOptions("console", console, NewJSONFormatter(false), OutputOptions{
+		Dedup: &DedupOptions{},
+	})
+	l.AddOutput("archive", archive, NewJSONFormatter(false))
+
+	for i := 0; i < 5; i++ {
+		l.Errorf(errors.New("refused"), "connect failed")
+	}
+	l.Infof("recovered")
+	for i := 0; i < 3; i++ {
+		l.Infof("idle")
+	}
+	l.Flush(context.Background())
+
+	lines := strings.Split(strings.TrimSpace(console.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("expected 5 lines, got %d:\n%s", len(lines), console.String())
+	}
+	if !strings.Contains(lines[1], `"message":"last message repeated 4 times"`) ||
+		!strings.Contains(lines[1], `"repeated":4`) || !strings.Contains(lines[1], `"first_repeat"`) {
+		t.Fatalf("unexpected summary: %s", lines[1])
+	}
+	if !strings.Contains(lines[2], `"recovered"`) || !strings.Contains(lines[4], `"repeated":2`) {
+		t.Fatalf("unexpected lines:\n%s", console.String())
+	}
+	if n := strings.Count(archive.String(), "\n"); n != 9 {
+		t.Fatalf("expected 9 archived lines, got %d", n)
+	}
+}
+
+func TestRedact(t *testing.T) {
+
+	archive := bytes.NewBuffer(nil)
+	hashed := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.SetRedactor(NewRedactor(RedactOptions{
+		Rules: DefaultRedactRules(RedactMask),
+	}))
+	l.AddOutput("archive", archive, NewJSONFormatter(false))
+	l.AddOutputOptions("hashed", hashed, NewSimpleFormatter(), OutputOptions{
+		Redactor: NewRedactor(RedactOptions{
+			Rules:   []RedactRule{{Keys: []string{"user"}, Mode: RedactHash}},
+			Allow:   []string{"user"},
+			HashKey: []byte("key"),
+		}),
+	})
+
+	f := NewFields()
+	f.Set("password", "hunter2")
+	f.Set("Authorization", "Bearer abc.def")
+	f.Set("credential", NewSecret("s3cr3t"))
+	f.Set("card", 4111111111111111)
+	f.Set("user", "joe")
+	f.Set("count", 42)
+	group := NewFields()
+	group.Set("password", "hunter3")
+	group.Set("contact", "jane@example.com")
+	f.Set("account", group)
+	f.Set("members", []*Fields{group})
+	l.With(f).Errorf(errors.New("dial 10.0.0.1:80 refused"), "mail joe@example.com, card 4111 1111 1111 1111, id 1234567890123")
+
+	out := archive.String() + hashed.String()
+	for _, secret := range []string{"hunter2", "hunter3", "jane@", "abc.def", "s3cr3t", "4111", "joe@", "10.0.0.1"} {
+		if strings.Contains(out, secret) {
+			t.Fatalf("secret %q leaked: %s", secret, out)
+		}
+	}
+	for _, want := range []string{`"count":42`, `"password":"[REDACTED]"`, `"credential":"[SECRET]"`, "id 1234567890123",
+		`"account":{"password":"[REDACTED]","contact":"[REDACTED]"}`} {
+		if !strings.Contains(archive.String(), want) {
+			t.Fatalf("expected %s in %s", want, archive.String())
+		}
+	}
+	if v, _ := group.Get("password"); v != "hunter3" {
+		t.Fatalf("nested fields modified: %v", v)
+	}
+	if strings.Contains(hashed.String(), "count") || !strings.Contains(hashed.String(), `"user"="hash:`) {
+		t.Fatalf("unexpected hashed output: %s", hashed.String())
+	}
+	validated := 0
+	l = New(nil)
+	l.AddOutput("out", bytes.NewBuffer(nil), NewSimpleFormatter())
+	l.SetSampling(&SamplingOptions{Window: time.Hour, Default: SamplingBudget{First: 1}})
+	l.SetRedactor(NewRedactor(RedactOptions{Rules: []RedactRule{{
+		Pattern:  regexp.MustCompile(`\d+`),
+		Validate: func(string) bool { validated++; return true },
+	}}}))
+	for i := 0; i < 10; i++ {
+		l.Infof("retry 42")
+	}
+	if validated != 1 {
+		t.Fatalf("expected only the sampled line redacted, got %d", validated)
+	}
+	if s := fmt.Sprintf("%v %+v %#v %s %x", NewSecret(1), NewSecret(1), NewSecret(1), NewSecret(1), NewSecret(1)); strings.Contains(s, "1") {
+		t.Fatalf("secret printed: %s", s)
+	}
+}
+
+func TestFieldsOrder(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+	l.AddOutput("simple", buf, NewSimpleFormatter())
+
+	f := NewFields()
+	f.Set("zulu", 1)
+	f.Set("alpha", 2)
+	f.Set("mike", 3)
+	l.With(f).Infof("ordered")
+	out := buf.String()
+	if !strings.Contains(out, `"message":"ordered","zulu":1,"alpha":2,"mike":3}`) ||
+		!strings.Contains(out, `ordered "zulu"="1" "alpha"="2" "mike"="3"`) {
+		t.Fatalf("unexpected insertion order:\n%s", out)
+	}
+
+	buf.Reset()
+	l.SetFieldOrder(OrderSorted)
+	l.With(f).Infof("sorted")
+	if !strings.Contains(buf.String(), `{"alpha":2,"loglevel":"Info","message":"sorted","mike":3,"time":`) ||
+		!strings.Contains(buf.String(), `sorted "alpha"="2" "mike"="3" "zulu"="1"`) {
+		t.Fatalf("unexpected sorted order:\n%s", buf.String())
+	}
+
+	f.SetOrder(OrderReservedFirst)
+	f.set(KeyMessage, "m")
+	f.set(KeyTime, time.Time{})
+	keys := []FieldKey{}
+	f.Walk(func(key FieldKey, val interface{}) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if fmt.Sprint(keys) != "[time message zulu alpha mike]" {
+		t.Fatalf("unexpected reserved first order: %v", keys)
+	}
+
+	c := f.Clone()
+	c.Set("alpha", 4)
+	c.Set("yankee", 5)
+	if v, _ := f.Get("alpha"); v != 2 || f.Len() != 5 || c.Len() != 6 {
+		t.Fatal("clone modified original")
+	}
+	f.Set("xray", 6)
+	if _, ok := c.Get("xray"); ok {
+		t.Fatal("original modified clone")
+	}
+
+	u := NewFields()
+	if err := json.Unmarshal([]byte(`{"b":1,"a":{"c":2}}`), u); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(u); string(data) != `{"b":1,"a":{"c":2}}` {
+		t.Fatalf("unexpected roundtrip: %s", data)
+	}
+}
+
+type stackerror struct {
+	callers []uintptr
+}
+
+func (se *stackerror) Error() string      { return "bad value" }
+func (se *stackerror) Callers() []uintptr { return se.callers }
+func newstackerror() error {
+	callers := make([]uintptr, 4)
+	return &stackerror{callers[:runtime.Callers(1, callers)]}
+}
+
+func TestErrorInfo(t *testing.T) {
+
+	root := newstackerror()
+	err := fmt.Errorf("load: %w", fmt.Errorf("parse: %w", root))
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+	f := NewFields()
+	f.Set("cleanup", errors.Join(errors.New("a"), errors.New("b")))
+	l.With(f).Errorf(err, "failed")
+
+	var v struct {
+		Error   ErrorInfo
+		Cleanup ErrorInfo
+	}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	cause := v.Error.Cause.Cause
+	if v.Error.Message != err.Error() || v.Error.Type != "*fmt.wrapError" || cause == nil ||
+		cause.Type != "*logex.stackerror" || len(cause.Frames) == 0 {
+		t.Fatalf("unexpected error: %s", buf.String())
+	}
+	if len(v.Cleanup.Causes) != 2 || v.Cleanup.Causes[1].Message != "b" {
+		t.Fatalf("unexpected custom error: %s", buf.String())
+	}
+
+	ef := NewFields()
+	ef.set(KeyError, err)
+	ef.Set("cleanup", errors.Join(errors.New("a"), errors.New("b")))
+	s := NewSimpleFormatter().Format(ef)
+	for _, want := range []string{
+		"\tError:\n\tload: parse: bad value\n\t\tcaused by: parse: bad value\n\t\t\tcaused by: bad value\n",
+		"logex_test.go (",
+		"\tcleanup:\n\ta\n\tb\n\t\tcaused by: a\n\t\tcaused by: b\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Fatalf("expected %q in:\n%s", want, s)
+		}
+	}
+}
+
+func TestErrorInfoErrorex(t *testing.T) {
+
+	cause := ErrUnmarshalLevel.WrapArgs("Bogus")
+	err := ErrLogex.WrapCause("reload failed", cause).Extra(ErrLogex.WrapData("dropped", 3))
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("json", buf, NewJSONFormatter(false))
+	l.Errorf(err, "failed")
+	var v struct{ Error ErrorInfo }
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+	ei := v.Error
+	if ei.Type != "*errorex.ErrorEx" || ei.Cause == nil || ei.Cause.Message != "logex" ||
+		len(ei.Causes) != 1 || ei.Causes[0].Message != "logex: error unmarshaling 'Bogus' as loglevel" ||
+		len(ei.Extras) != 1 || ei.Extras[0].Data != float64(3) {
+		t.Fatalf("unexpected error: %s", buf.String())
+	}
+
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, LevelError)
+	f.set(KeyMessage, "failed")
+	f.set(KeyError, err)
+	for _, c := range []struct {
+		name string
+		out  string
+		want []string
+	}{
+		{"simple", NewSimpleFormatter().Format(f), []string{
+			"\t\tcaused by: logex\n",
+			"\t\tcaused by: logex: error unmarshaling 'Bogus' as loglevel\n",
+			"\t\textra: logex: dropped 3\n",
+		}},
+		{"console", NewConsoleFormatter(nil).Format(f), []string{
+			"        caused by: logex\n",
+			"        caused by: logex: error unmarshaling 'Bogus' as loglevel\n",
+			"        extra: logex: dropped 3\n",
+		}},
+	} {
+		for _, want := range c.want {
+			if !strings.Contains(c.out, want) {
+				t.Fatalf("%s: expected %q in:\n%s", c.name, want, c.out)
+			}
+		}
+	}
+}
+
+func TestAppendFormatter(t *testing.T) {
+
+	for _, val := range []interface{}{
+		"plain", "quote\" back\\ <html> & \n\t\r\x01 \u2028 \u2029 \xff \u017e", 42, int8(-8), uint64(1 << 63),
+		3.0, 1e21, 1e-7, float32(0.1), 123456.789, true, nil, time.Date(2020, 3, 3, 13, 0, 0, 5, time.UTC),
+		time.Second, []int{1, 2}, map[string]int{"a": 1}, NewSecret("x"),
+	} {
+		expected, err := json.Marshal(val)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := appendjsonvalue(nil, val, false, 0); string(s) != string(expected) {
+			t.Fatalf("expected %s, got %s", expected, s)
+		}
+	}
+
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, LevelInfo)
+	f.set(KeyMessage, "message")
+	f.set(KeyFrames, []*Fields{NewFields()})
+	f.Set("nested", map[string]int{"a": 1})
+	expected, _ := json.MarshalIndent(f, "", "\t")
+	if s := NewJSONFormatter(true).Format(f); s != string(expected)+"\n" {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, s)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	tf, err := NewTemplateFormatter("{{.Message}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.AddOutput("template", buf, tf)
+	l.Infof("adapted")
+	if buf.String() != "adapted\n" {
+		t.Fatalf("unexpected adapted output: %q", buf.String())
+	}
+
+	var kept *Fields
+	l = New(nil)
+	l.AddOutputOptions("out", &fakewriter{}, NewJSONFormatter(false), OutputOptions{
+		Filter: func(f *Fields) bool {
+			if kept == nil {
+				kept = f.Clone()
+			}
+			return true
+		},
+	})
+	l.Infof("first")
+	l.Warningf("second")
+	if kept.Message() != "first" || kept.LogLevel() != LevelInfo || kept.Time().IsZero() {
+		t.Fatalf("clone of a written line changed: %v %v", kept.Message(), kept.LogLevel())
+	}
+}
+
+func TestEnabled(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.AddOutput("out", buf, NewSimpleFormatter())
+	l.SetLevel(LevelInfo)
+
+	if l.Enabled(LevelDebug) || !l.Enabled(LevelInfo) || !l.WithLevel(LevelError).Enabled(LevelError) ||
+		l.WithLevel(LevelError).Enabled(LevelWarning) {
+		t.Fatal("unexpected Enabled result")
+	}
+	evaluated := false
+	expensive := func() string { evaluated = true; return "" }
+	if v := l.V(LevelDebug); v.Enabled(LevelError) {
+		t.Fatal("no-op Log enabled")
+	} else {
+		v.With(NewFields()).Debugf("%s", "discarded")
+	}
+	if l.Enabled(LevelDebug) {
+		l.Debugf("%s", expensive())
+	}
+	l.V(LevelInfo).Infof("kept")
+	l.Debugf("discarded")
+	if evaluated || !strings.Contains(buf.String(), "kept") ||
+		strings.Contains(buf.String(), "discarded") {
+		t.Fatalf("unexpected output: %q", buf.String())
+	}
+	if n := testing.AllocsPerRun(100, func() { l.Debugf("disabled") }); n != 0 {
+		t.Fatalf("disabled level allocated %v times", n)
+	}
+}
+
+type countingformatter struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (cf *countingformatter) Format(fields *Fields) string {
+	cf.mu.Lock()
+	cf.n++
+	cf.mu.Unlock()
+	return fields.Message()
+}
+
+func TestOutputTimeout(t *testing.T) {
+
+	var mu sync.Mutex
+	timeouts := 0
+	bw := &blockingwriter{release: make(chan struct{})}
+	file := bytes.NewBuffer(nil)
+	l := New(func(err error) {
+		if errors.Is(err, ErrWriteTimeout) {
+			mu.Lock()
+			timeouts++
+			mu.Unlock()
+		}
+	})
+	l.AddOutputOptions("net", bw, NewSimpleFormatter(), OutputOptions{WriteTimeout: 20 * time.Millisecond})
+	l.AddOutput("file", file, NewSimpleFormatter())
+
+	start := time.Now()
+	l.Infoln("first")
+	l.Infoln("second")
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("hung writer blocked for %v", d)
+	}
+	mu.Lock()
+	if timeouts != 2 {
+		t.Fatalf("expected 2 timeouts, got %d", timeouts)
+	}
+	mu.Unlock()
+	if !strings.Contains(file.String(), "first") || !strings.Contains(file.String(), "second") {
+		t.Fatalf("file output blocked: %q", file.String())
+	}
+
+	close(bw.release)
+	if err := l.SetOutputOptions("net", OutputOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	l.Infoln("third")
+	bw.mu.Lock()
+	if bw.lines != 2 {
+		t.Fatalf("expected 2 written lines, got %d", bw.lines)
+	}
+	bw.mu.Unlock()
+
+	hung1 := &blockingwriter{release: make(chan struct{})}
+	hung2 := &blockingwriter{release: make(chan struct{})}
+	defer close(hung1.release)
+	defer close(hung2.release)
+	l = New(nil)
+	l.AddOutputOptions("hung1", hung1, NewSimpleFormatter(), OutputOptions{WriteTimeout: 100 * time.Millisecond})
+	l.AddOutputOptions("hung2", hung2, NewSimpleFormatter(), OutputOptions{WriteTimeout: 100 * time.Millisecond})
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		l.Infoln("line")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("stalled outputs blocked for %v", d)
+	}
+
+	cf := &countingformatter{}
+	a, b := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	l = New(nil)
+	l.AddOutput("a", a, cf)
+	l.AddOutput("b", b, cf)
+	l.AddOutput("c", bytes.NewBuffer(nil), &countingformatter{})
+	l.Infof("shared")
+	if cf.n != 1 || a.String() != "shared" || b.String() != "shared" {
+		t.Fatalf("expected one shared format, got %d: %q %q", cf.n, a.String(), b.String())
+	}
+}
+
+type slowwriter struct {
+	mu    sync.Mutex
+	delay time.Duration
+	buf   bytes.Buffer
+}
+
+func (sw *slowwriter) Write(p []byte) (int, error) {
+	time.Sleep(sw.delay)
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.buf.Write(p)
+}
+
+func (sw *slowwriter) String() string {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	return sw.buf.String()
+}
+
+type ctxflusher struct {
+	bytes.Buffer
+	flushed bool
+}
+
+func (cf *ctxflusher) Flush(ctx context.Context) error {
+	cf.flushed = ctx.Err() == nil
+	return nil
+}
+
+func TestSeverityLevels(t *testing.T) {
+
+	levels := []LogLevel{LevelEmergency, LevelAlert, LevelCritical, LevelError,
+		LevelWarning, LevelNotice, LevelInfo, LevelDebug, LevelTrace, LevelCustom}
+	for i, level := range levels {
+		if i > 0 && level <= levels[i-1] {
+			t.Fatalf("%s not less severe than %s", level, levels[i-1])
+		}
+		var parsed LogLevel
+		text, _ := level.MarshalText()
+		if err := parsed.UnmarshalText(text); err != nil || parsed != level {
+			t.Fatalf("%s: text round trip failed: %v %v", level, parsed, err)
+		}
+		if level > LevelDebug {
+			continue
+		}
+		if sev := syslogseverity(level); sev != i || LevelFromSyslog(sev) != level {
+			t.Fatalf("%s: unexpected syslog severity %d", level, sev)
+		}
+		if n, _ := otelseverity(level); LevelFromOTel(n) != level {
+			t.Fatalf("%s: OTel severity %d maps back to %s", level, n, LevelFromOTel(n))
+		}
+	}
+	for text, expected := range map[string]LogLevel{
+		"Custom(11)":  LevelCustom,
+		"custom(254)": LevelPrint - 1,
+		"Custom(7)":   LevelNone,
+		"custom(3)":   LevelNone,
+		"Custom(10)":  LevelNone,
+		"Custom(255)": LevelNone,
+		"custom(300)": LevelNone,
+		"Custom(-1)":  LevelNone,
+		"Custom(x)":   LevelNone,
+	} {
+		var parsed LogLevel
+		err := parsed.UnmarshalText([]byte(text))
+		if expected == LevelNone {
+			if !errors.Is(err, ErrUnmarshalLevel) {
+				t.Fatalf("%s: expected ErrUnmarshalLevel, got %v %v", text, parsed, err)
+			}
+			continue
+		}
+		if err != nil || parsed != expected {
+			t.Fatalf("%s: expected %v, got %v %v", text, expected, parsed, err)
+		}
+	}
+	var lvl LogLevel
+	if err := json.Unmarshal([]byte(`"notice"`), &lvl); err != nil || lvl != LevelNotice {
+		t.Fatalf("JSON name not unmarshaled: %v %v", lvl, err)
+	}
+	if err := json.Unmarshal([]byte(`{"loglevel":4}`), NewFields()); !errors.Is(err, ErrUnmarshalLevel) {
+		t.Fatalf("old numeric level not rejected: %v", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	l := New(nil)
+	l.SetLevel(LevelTrace)
+	l.AddOutput("out", buf, NewLogfmtFormatter())
+	l.Tracef("trace")
+	l.Noticef("notice")
+	l.Criticalf(nil, "critical")
+	l.Alertf(nil, "alert")
+	l.Emergencyf(nil, "emergency")
+	for _, s := range []string{"level=trace", "level=notice", "level=critical", "level=alert", "level=emergency"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("%q not logged:\n%s", s, buf.String())
+		}
+	}
+
+	defer func() { exit = os.Exit }()
+	code := -1
+	exit = func(c int) { code = c }
+	slow := &slowwriter{delay: 10 * time.Millisecond}
+	l = New(nil)
+	l.AddAsyncOutput("slow", slow, NewLogfmtFormatter(), &AsyncOptions{})
+	l.V(LevelTrace).Fatalf(nil, "disabled")
+	if code != 1 {
+		t.Fatal("Fatalf did not exit")
+	}
+	code = -1
+	cfw := &ctxflusher{}
+	l.AddOutput("batched", cfw, NewLogfmtFormatter())
+	l.Infof("queued")
+	l.Fatalf(errors.New("boom"), "fatal")
+	if code != 1 || !strings.Contains(slow.String(), "queued") || !strings.Contains(slow.String(), "level=critical msg=fatal") {
+		t.Fatalf("Fatalf did not flush before exit: %d %q", code, slow.String())
+	}
+	if !cfw.flushed {
+		t.Fatal("Fatalf did not flush a writer with a context Flush")
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "panic 1" {
+				t.Fatalf("unexpected panic value: %v", r)
+			}
+		}()
+		l.Panicf(nil, "panic %d", 1)
+	}()
+	if !strings.Contains(slow.String(), "msg=\"panic 1\"") {
+		t.Fatalf("Panicf did not flush before panic: %q", slow.String())
+	}
+}
+
+func TestRegisterLevel(t *testing.T) {
+
+	audit := LevelCustom + 100
+	defer func() {
+		levels.mu.Lock()
+		delete(levels.values, audit)
+		delete(levels.values, audit+1)
+		delete(levels.names, "audit")
+		delete(levels.names, "security")
+		levels.mu.Unlock()
+	}()
+	if err := RegisterLevel(audit, "AUDIT", &LevelOptions{Color: ColorCyan, Severity: LevelNotice}); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		value LogLevel
+		name  string
+		opts  *LevelOptions
+	}{
+		{audit, "Other", nil},
+		{audit + 1, "audit", nil},
+		{audit + 1, "Warning", nil},
+		{audit + 1, "custom7", nil},
+		{audit + 1, "two words", nil},
+		{LevelInfo, "Information", nil},
+		{LevelPrint, "Everything", nil},
+		{audit + 1, "Bad", &LevelOptions{OTelSeverity: 25}},
+		{audit + 1, "Bad", &LevelOptions{Severity: audit}},
+	} {
+		if err := RegisterLevel(c.value, c.name, c.opts); err == nil {
+			t.Fatalf("registered %d %q", c.value, c.name)
+		}
+	}
+
+	var wg sync.WaitGroup
+	var registered int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if RegisterLevel(audit+1, "SECURITY", &LevelOptions{OTelSeverity: 18}) == nil {
+				atomic.AddInt32(&registered, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if registered != 1 {
+		t.Fatalf("level registered %d times", registered)
+	}
+
+	if s := audit.String(); s != "AUDIT" {
+		t.Fatalf("unexpected String: %q", s)
+	}
+	var lvl LogLevel
+	if err := lvl.UnmarshalText([]byte("security")); err != nil || lvl != audit+1 {
+		t.Fatalf("unmarshal failed: %v %v", lvl, err)
+	}
+	lvl = audit + 2
+	if text, _ := lvl.MarshalText(); string(text) != fmt.Sprintf("Custom(%d)", lvl) {
+		t.Fatalf("unexpected unregistered level text: %s", text)
+	}
+
+	if sev := syslogseverity(audit); sev != 5 {
+		t.Fatalf("unexpected syslog severity %d", sev)
+	}
+	if n, text := otelseverity(audit + 1); n != 18 || text != "SECURITY" {
+		t.Fatalf("unexpected OTel severity %d %s", n, text)
+	}
+	if n, text := otelseverity(audit); n != 10 || text != "AUDIT" {
+		t.Fatalf("unexpected OTel severity %d %s", n, text)
+	}
+
+	f := NewFields()
+	f.set(KeyTime, time.Date(2020, 3, 3, 13, 0, 0, 0, time.UTC))
+	f.set(KeyLogLevel, audit)
+	f.set(KeyMessage, "login")
+	if s := NewSimpleFormatter().Format(f); !strings.Contains(s, "AUDIT: login") {
+		t.Fatalf("unexpected simple output: %q", s)
+	}
+	if s := NewLogfmtFormatter().Format(f); !strings.Contains(s, "level=audit ") {
+		t.Fatalf("unexpected logfmt output: %q", s)
+	}
+	data, _ := f.MarshalJSON()
+	if !strings.Contains(string(data), `"loglevel":"AUDIT"`) {
+		t.Fatalf("unexpected JSON output: %s", data)
+	}
+	parsed := NewFields()
+	if err := json.Unmarshal(data, parsed); err != nil || parsed.LogLevel() != audit {
+		t.Fatalf("JSON round trip failed: %v %v", parsed.LogLevel(), err)
+	}
+	cf := NewConsoleFormatter(nil)
+	cf.Colors = true
+	if s := cf.Format(f); !strings.Contains(s, string(ColorCyan)+"AUDIT") {
+		t.Fatalf("unexpected console output: %q", s)
+	}
+}
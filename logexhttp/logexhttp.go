@@ -0,0 +1,250 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+// Package logexhttp implements HTTP access and request logging using logex.
+package logexhttp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vedranvuk/logex"
+)
+
+// DefaultRequestIDHeader is the default request id header name.
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// Access log field keys.
+const (
+	// KeyMethod specifies that field carries the request method.
+	KeyMethod logex.FieldKey = "method"
+	// KeyPath specifies that field carries the request path.
+	KeyPath logex.FieldKey = "path"
+	// KeyStatus specifies that field carries the response status.
+	KeyStatus logex.FieldKey = "status"
+	// KeyBytes specifies that field carries the number of response body bytes.
+	KeyBytes logex.FieldKey = "bytes"
+	// KeyDuration specifies that field carries the request duration.
+	KeyDuration logex.FieldKey = "duration"
+	// KeyRemoteAddr specifies that field carries the client address.
+	KeyRemoteAddr logex.FieldKey = "remote_addr"
+	// KeyUserAgent specifies that field carries the client user agent.
+	KeyUserAgent logex.FieldKey = "user_agent"
+	// KeyRequestID specifies that field carries the request id.
+	KeyRequestID logex.FieldKey = "request_id"
+)
+
+// Options defines Middleware options.
+type Options struct {
+	// RequestIDHeader is the header a request id is read from and written
+	// to. Defaults to DefaultRequestIDHeader.
+	RequestIDHeader string
+	// TrustRequestID uses the request id from the request header if set.
+	// Otherwise a new request id is always generated.
+	TrustRequestID bool
+	// CombinedLog, if not nil, receives a line in Apache Combined Log Format
+	// for each request.
+	CombinedLog io.Writer
+	// StackDepth is the depth of the stack logged for recovered panics.
+	// Defaults to 32.
+	StackDepth int
+}
+
+// Middleware returns a middleware that logs each request to l.
+//
+// Each request is assigned a request id which is written to the response
+// header. A Log derived from l with request id, method and path fields and
+// trace fields from a "traceparent" header is put into the request context
+// and can be retrieved with logex.FromContext.
+//
+// Panics in the handler are recovered, logged with a stack and answered
+// with status 500 if the response was not started. http.ErrAbortHandler
+// panics are propagated.
+func Middleware(l logex.Log, opts *Options) func(http.Handler) http.Handler {
+	o := Options{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.RequestIDHeader == "" {
+		o.RequestIDHeader = DefaultRequestIDHeader
+	}
+	if o.StackDepth <= 0 {
+		o.StackDepth = 32
+	}
+	var clmu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			id := ""
+			if o.TrustRequestID {
+				id = r.Header.Get(o.RequestIDHeader)
+			}
+			if id == "" {
+				id = newrequestid()
+			}
+			w.Header().Set(o.RequestIDHeader, id)
+
+			f := logex.NewFields()
+			f.Set(KeyRequestID, id)
+			f.Set(KeyMethod, r.Method)
+			f.Set(KeyPath, r.URL.Path)
+			ctx := r.Context()
+			if tc, err := logex.ParseTraceparent(r.Header.Get(logex.TraceparentHeader)); err == nil {
+				ctx = logex.ContextWithTrace(ctx, tc)
+				tc.Fields().Walk(func(key logex.FieldKey, val interface{}) bool {
+					f.Set(key, val)
+					return true
+				})
+			}
+			rl := l.With(f)
+			r = r.WithContext(logex.NewContext(ctx, rl))
+
+			rw := &responsewriter{ResponseWriter: w, status: http.StatusOK}
+			defer func() {
+				if v := recover(); v != nil {
+					if v == http.ErrAbortHandler {
+						panic(v)
+					}
+					err, ok := v.(error)
+					if !ok {
+						err = fmt.Errorf("%v", v)
+					}
+					rl.WithStack(3, o.StackDepth).Errorf(err, "panic serving %s", r.URL.Path)
+					if !rw.wroteheader {
+						rw.WriteHeader(http.StatusInternalServerError)
+					}
+				}
+				duration := time.Since(start)
+
+				af := logex.NewFields()
+				af.Set(KeyStatus, rw.status)
+				af.Set(KeyBytes, rw.bytes)
+				af.Set(KeyDuration, duration)
+				af.Set(KeyRemoteAddr, r.RemoteAddr)
+				af.Set(KeyUserAgent, r.UserAgent())
+				rl.WithFields(af).Infof("%s %s %d", r.Method, r.URL.RequestURI(), rw.status)
+
+				if o.CombinedLog != nil {
+					clmu.Lock()
+					io.WriteString(o.CombinedLog, combined(r, rw, start))
+					clmu.Unlock()
+				}
+			}()
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// newrequestid returns a new random request id.
+func newrequestid() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// combined returns an Apache Combined Log Format line for a request.
+func combined(r *http.Request, rw *responsewriter, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	user := "-"
+	if u, _, ok := r.BasicAuth(); ok && u != "" {
+		user = u
+	} else if r.URL.User != nil && r.URL.User.Username() != "" {
+		user = r.URL.User.Username()
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %d %s %s\n",
+		host,
+		clfescape(user),
+		start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method,
+		clfescape(r.URL.RequestURI()),
+		r.Proto,
+		rw.status,
+		rw.bytes,
+		clfquote(r.Referer()),
+		clfquote(r.UserAgent()),
+	)
+}
+
+// clfescape escapes quotes, backslashes and control characters in s.
+func clfescape(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(strconv.Quote(s), `"`), `"`)
+}
+
+// clfquote returns s quoted or "-" if s is empty.
+func clfquote(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return `"` + clfescape(s) + `"`
+}
+
+// responsewriter records the status and size of a response.
+type responsewriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteheader bool
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rw *responsewriter) WriteHeader(status int) {
+	if rw.wroteheader {
+		return
+	}
+	rw.status = status
+	rw.wroteheader = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (rw *responsewriter) Write(p []byte) (int, error) {
+	if !rw.wroteheader {
+		rw.WriteHeader(http.StatusOK)
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (rw *responsewriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		if !rw.wroteheader {
+			rw.WriteHeader(http.StatusOK)
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. It returns http.ErrNotSupported if the
+// wrapped http.ResponseWriter is not an http.Hijacker.
+func (rw *responsewriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		rw.wroteheader = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for http.ResponseController.
+func (rw *responsewriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
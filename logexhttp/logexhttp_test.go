@@ -0,0 +1,136 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logexhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/vedranvuk/logex"
+)
+
+func TestMiddleware(t *testing.T) {
+
+	buf := bytes.NewBuffer(nil)
+	clf := bytes.NewBuffer(nil)
+	l := logex.New(nil)
+	l.AddOutput("json", buf, logex.NewJSONFormatter(false))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		logex.FromContext(r.Context()).Infof("handling")
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	mux.HandleFunc("/panicerror", func(w http.ResponseWriter, r *http.Request) {
+		panic(fmt.Errorf("boom: %w", io.ErrUnexpectedEOF))
+	})
+	h := Middleware(l, &Options{CombinedLog: clf, TrustRequestID: true})(mux)
+
+	req := httptest.NewRequest("GET", "/hello?x=1", nil)
+	req.Header.Set(DefaultRequestIDHeader, "req-1")
+	req.Header.Set("User-Agent", "test")
+	req.Header.Set(logex.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Header().Get(DefaultRequestIDHeader) != "req-1" {
+		t.Fatal("request id not propagated")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+	if rec.Header().Get(DefaultRequestIDHeader) == "" {
+		t.Fatal("request id not generated")
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %d:\n%s", len(lines), buf.String())
+	}
+	var handling, access, panicked, panicaccess map[string]interface{}
+	for i, v := range []*map[string]interface{}{&handling, &access, &panicked, &panicaccess} {
+		if err := json.Unmarshal([]byte(lines[i]), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if handling["request_id"] != "req-1" || handling["trace_id"] != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("request logger missing fields: %s", lines[0])
+	}
+	if access["status"] != float64(200) || access["bytes"] != float64(5) ||
+		access["user_agent"] != "test" || access["path"] != "/hello" {
+		t.Fatalf("unexpected access line: %s", lines[1])
+	}
+	if frames, _ := panicked["frames"].([]interface{}); len(frames) == 0 {
+		t.Fatalf("panic logged without stack: %s", lines[2])
+	}
+	if panicaccess["status"] != float64(500) {
+		t.Fatalf("unexpected access line: %s", lines[3])
+	}
+
+	if !strings.Contains(clf.String(), `"GET /hello?x=1 HTTP/1.1" 200 5 "-" "test"`) {
+		t.Fatalf("unexpected combined log: %s", clf.String())
+	}
+
+	buf.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panicerror", nil))
+	var v struct{ Error logex.ErrorInfo }
+	if err := json.Unmarshal([]byte(strings.SplitN(buf.String(), "\n", 2)[0]), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Error.Type != "*fmt.wrapError" || v.Error.Cause == nil || v.Error.Cause.Message != io.ErrUnexpectedEOF.Error() {
+		t.Fatalf("panic error chain lost: %s", buf.String())
+	}
+}
+
+func TestMiddlewareHijack(t *testing.T) {
+
+	h := Middleware(logex.New(nil), nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("response writer is not an http.Flusher")
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("response writer is not an http.Hijacker")
+			return
+		}
+		conn, brw, err := hj.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		brw.Flush()
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+
+	if _, _, err := (&responsewriter{ResponseWriter: httptest.NewRecorder()}).Hijack(); err != http.ErrNotSupported {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+}
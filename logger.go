@@ -5,17 +5,271 @@
 package logex
 
 import (
+	"context"
 	"io"
 	"os"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // output defines a Logger output.
+//
+// Outputs are locked independently of the Logger and of each other while
+// a line is filtered, formatted and written.
 type output struct {
+	// name is the output name.
+	name string
 	// w is the writer of the output.
 	w io.Writer
 	// f is the formatter used on the output.
 	f Formatter
+	// af is f as an AppendFormatter.
+	af AppendFormatter
+	// shared is true if lines formatted by f can be shared with other
+	// outputs using the same formatter.
+	shared bool
+	// sem locks the output. It is a channel so that locking can time out.
+	sem chan struct{}
+	// timeout is the write timeout, accessed atomically.
+	timeout int64
+	// state is the state of a write with a timeout, accessed atomically.
+	state int32
+	// q is the queue of an async output, nil if synchronous.
+	q *queue
+	// opts are the output options.
+	opts OutputOptions
+	// dd is the duplicate line suppressor, nil if disabled.
+	dd *dedup
+}
+
+// newoutput returns a new output.
+func newoutput(name string, w io.Writer, f Formatter, opts OutputOptions) *output {
+	return &output{
+		name:    name,
+		w:       w,
+		f:       f,
+		af:      NewAppendFormatter(f),
+		shared:  f != nil && reflect.TypeOf(f).Comparable(),
+		sem:     make(chan struct{}, 1),
+		timeout: int64(opts.WriteTimeout),
+		opts:    opts,
+		dd:      newdedup(opts.Dedup),
+	}
+}
+
+// lock locks the output waiting at most for the write timeout, if set.
+// It returns false if the output could not be locked in time.
+func (o *output) lock() bool {
+	select {
+	case o.sem <- struct{}{}:
+		return true
+	default:
+	}
+	timeout := time.Duration(atomic.LoadInt64(&o.timeout))
+	if timeout <= 0 {
+		o.sem <- struct{}{}
+		return true
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case o.sem <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// lockline locks the output to write a line. Unlike lock it fails
+// immediately while a write that timed out is still in progress.
+func (o *output) lockline() bool {
+	if atomic.LoadInt32(&o.state) == writestalled {
+		return false
+	}
+	return o.lock()
+}
+
+// unlock unlocks the output.
+func (o *output) unlock() { <-o.sem }
+
+// timedout reports a write timeout of the output to ef if not nil.
+func (o *output) timedout(ef ErrorFunc) {
+	if ef != nil {
+		ef(ErrWriteTimeout.WrapArgs(o.name))
+	}
+}
+
+// accepts returns if the output accepts a line with specified fields.
+func (o *output) accepts(fields *Fields) bool {
+	lvl := fields.LogLevel()
+	if o.opts.MinLevel != LevelNone && lvl < o.opts.MinLevel {
+		return false
+	}
+	if o.opts.MaxLevel != LevelNone && lvl > o.opts.MaxLevel {
+		return false
+	}
+	if o.opts.Filter != nil && !o.opts.Filter(fields) {
+		return false
+	}
+	return true
+}
+
+// write writes fields to output if accepted by output options.
+// Write errors are reported to ef if not nil. Formatted lines are shared
+// with other outputs through fc if not nil. A write with a timeout is
+// started on a separate goroutine and appended to pending.
+func (o *output) write(fields *Fields, ef ErrorFunc, fc *formatcache, pending []inflight) []inflight {
+	if !o.lockline() {
+		o.timedout(ef)
+		return pending
+	}
+	if o.opts.Redactor != nil {
+		fields = o.opts.Redactor.Redact(fields)
+	}
+	if !o.accepts(fields) {
+		o.unlock()
+		return pending
+	}
+	var w inflight
+	if o.dd != nil {
+		w = o.emit(ef, fc, o.dd.filter(fields)...)
+	} else {
+		w = o.emit(ef, fc, fields)
+	}
+	if w.done != nil {
+		pending = append(pending, w)
+	}
+	return pending
+}
+
+// flushdedup writes the summary of suppressed duplicate lines, if any.
+func (o *output) flushdedup(ef ErrorFunc) {
+	if !o.lock() {
+		o.timedout(ef)
+		return
+	}
+	if o.dd != nil {
+		if summary := o.dd.summary(); summary != nil {
+			o.emit(ef, nil, summary).wait(ef)
+			return
+		}
+	}
+	o.unlock()
+}
+
+// Write states of an output with a write timeout.
+const (
+	// writeidle is the state of an output with no write in progress.
+	writeidle int32 = iota
+	// writebusy is the state of an output with a write in progress.
+	writebusy
+	// writestalled is the state of an output whose write timed out and is
+	// still in progress.
+	writestalled
+)
+
+// inflight is a write in progress on a separate goroutine.
+type inflight struct {
+	o        *output
+	done     chan struct{}
+	deadline time.Time
+}
+
+// wait waits for the write to complete until its deadline and reports a
+// timeout to ef otherwise. An output whose write timed out stays stalled
+// until the write completes.
+func (w inflight) wait(ef ErrorFunc) {
+	if w.done == nil {
+		return
+	}
+	timer := time.NewTimer(time.Until(w.deadline))
+	defer timer.Stop()
+	select {
+	case <-w.done:
+	case <-timer.C:
+		if atomic.CompareAndSwapInt32(&w.o.state, writebusy, writestalled) {
+			w.o.timedout(ef)
+		}
+	}
+}
+
+// emit formats lines and queues them if the output is async or writes
+// them otherwise. The output must be locked and emit unlocks it.
+//
+// If the output has a write timeout lines are written from a separate
+// goroutine and the returned inflight must be waited on. The output stays
+// locked until the write completes.
+func (o *output) emit(ef ErrorFunc, fc *formatcache, lines ...*Fields) inflight {
+	timeout := time.Duration(atomic.LoadInt64(&o.timeout))
+	if o.q != nil || timeout <= 0 {
+		for _, fields := range lines {
+			lb := fc.format(o, fields)
+			if o.q == nil || !o.q.put(queueitem{level: fields.LogLevel(), buf: lb}) {
+				o.writeline(lb, ef)
+			}
+		}
+		o.unlock()
+		return inflight{}
+	}
+	lbs := make([]*linebuf, 0, len(lines))
+	for _, fields := range lines {
+		lbs = append(lbs, fc.format(o, fields))
+	}
+	atomic.StoreInt32(&o.state, writebusy)
+	done := make(chan struct{})
+	go func() {
+		for _, lb := range lbs {
+			o.writeline(lb, ef)
+		}
+		atomic.StoreInt32(&o.state, writeidle)
+		o.unlock()
+		close(done)
+	}()
+	return inflight{o, done, time.Now().Add(timeout)}
+}
+
+// writeline writes lb to the output writer and releases it.
+// Write errors are reported to ef if not nil.
+func (o *output) writeline(lb *linebuf, ef ErrorFunc) {
+	if _, err := o.w.Write(lb.data); err != nil && ef != nil {
+		ef(err)
+	}
+	lb.release()
+}
+
+// OutputOptions defines options of an output.
+//
+// Levels are compared by value where a lower value is more severe, i.e.
+// MaxLevel of LevelWarning writes warnings and errors only.
+type OutputOptions struct {
+	// MinLevel is the lowest logging level written to the output.
+	// LevelNone sets no limit.
+	MinLevel LogLevel
+	// MaxLevel is the highest logging level written to the output.
+	// LevelNone sets no limit.
+	MaxLevel LogLevel
+	// Filter is an optional func that must return true for a line to be
+	// written to the output. It is called after the level checks. Fields
+	// are reused once the line is written and must not be retained; use
+	// Fields.Clone to keep them.
+	Filter func(*Fields) bool
+	// Redactor, if not nil, redacts lines before they are filtered and
+	// formatted for the output.
+	Redactor *Redactor
+	// Dedup, if not nil, enables suppression of duplicate lines.
+	Dedup *DedupOptions
+	// WriteTimeout, if not 0, is the maximum time a synchronous write to
+	// the output may take. Outputs with a write timeout are written
+	// concurrently with other outputs. A write that times out is reported
+	// to the ErrorFunc of the Logger as ErrWriteTimeout and lines logged
+	// while it is still in progress are discarded immediately and reported
+	// as well.
+	WriteTimeout time.Duration
+	// Async, if not nil, makes the output asynchronous like AddAsyncOutput.
+	// Async cannot be changed after the output is added.
+	Async *AsyncOptions
 }
 
 // outputmap is a map of output names to outputs.
@@ -26,65 +280,192 @@ type outputmap map[string]*output
 type ErrorFunc func(err error)
 
 // Logger is an implementation of Log.
+//
+// Logger holds no per-line state and is safe for concurrent use. Each call
+// to a logging or With* method starts a fresh line from an immutable root.
 type Logger struct {
-	Log
+	root *Line
 
 	mu      sync.Mutex
 	outputs outputmap
-	lvl     LogLevel
+	outlist []*output
+	lvl     uint32
 	ef      ErrorFunc
+	queue   *queue
+	dropped uint64
+
+	extractors []ContextExtractor
+	sampler    *sampler
+	redactor   *Redactor
+	order      FieldOrder
 }
 
-// print prints fields to registered writers using associated formatters.
+// print prints fields to registered writers using associated formatters
+// or queues them if the Logger is async.
 func (l *Logger) print(fields *Fields, outputnames ...string) {
 	l.mu.Lock()
-	defer l.mu.Unlock()
+	if fields.LogLevel() > l.level() {
+		l.mu.Unlock()
+		fields.release()
+		return
+	}
+	q, s, r, order := l.queue, l.sampler, l.redactor, l.order
+	fields.SetOrder(order)
+	l.mu.Unlock()
+
+	if s != nil {
+		keep, expired := s.sample(fields)
+		for _, summary := range expired {
+			summary.SetOrder(order)
+			l.dispatch(q, r, summary, nil)
+		}
+		if !keep {
+			fields.release()
+			return
+		}
+	}
+	l.dispatch(q, r, fields, outputnames)
+}
 
-	if fields.LogLevel() > l.lvl {
+// dispatch redacts fields using r if not nil and queues them if the
+// Logger is async or writes them otherwise. Lines are sampled before they
+// are redacted so that dropped lines are not redacted. Pooled fields are
+// released once written.
+func (l *Logger) dispatch(q *queue, r *Redactor, fields *Fields, outputnames []string) {
+	if r != nil {
+		redacted := r.Redact(fields)
+		fields.release()
+		fields = redacted
+	}
+	if q != nil && q.put(queueitem{level: fields.LogLevel(), fields: fields, names: outputnames}) {
 		return
 	}
-	var err error
-	var out *output
-	var ok bool
+	l.write(fields, outputnames...)
+	fields.release()
+}
+
+// write writes fields to registered writers using associated formatters.
+// Outputs are written independently of each other, and lines formatted by
+// the same formatter are formatted once. Writes with a timeout run
+// concurrently and are waited on after all outputs have been written.
+func (l *Logger) write(fields *Fields, outputnames ...string) {
+	var fc formatcache
+	var buf [4]inflight
+	pending := buf[:0]
 	if len(outputnames) > 0 {
 		for _, name := range outputnames {
-			if out, ok = l.outputs[name]; ok {
-				if _, err = out.w.Write([]byte(out.f.Format(fields))); err != nil && l.ef != nil {
-					l.ef(err)
-				}
+			l.mu.Lock()
+			out, ok := l.outputs[name]
+			l.mu.Unlock()
+			if ok {
+				pending = out.write(fields, l.ef, &fc, pending)
 			}
 		}
 	} else {
-		for _, out = range l.outputs {
-			if _, err = out.w.Write([]byte(out.f.Format(fields))); err != nil && l.ef != nil {
-				l.ef(err)
-			}
+		for _, out := range l.outputlist() {
+			pending = out.write(fields, l.ef, &fc, pending)
 		}
 	}
-	l.Log = NewLine(l)
+	for _, w := range pending {
+		w.wait(l.ef)
+	}
+	fc.release()
+}
+
+// outputlist returns outputs in order they were added.
+// The returned slice must not be modified.
+func (l *Logger) outputlist() []*output {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.outlist
 }
 
 // AddOutput registers an output writer with formatter f unser specified
 // name which must be unique and not empty or returns an error.
 func (l *Logger) AddOutput(name string, w io.Writer, f Formatter) error {
+	return l.addOutput(newoutput(name, w, f, OutputOptions{}))
+}
+
+// AddOutputOptions registers an output like AddOutput using specified options.
+func (l *Logger) AddOutputOptions(name string, w io.Writer, f Formatter, opts OutputOptions) error {
+	out := newoutput(name, w, f, opts)
+	if opts.Async != nil {
+		ef := l.ef
+		out.q = newqueue(opts.Async, func(item *queueitem) {
+			out.writeline(item.buf, ef)
+		})
+	}
+	if err := l.addOutput(out); err != nil {
+		if out.q != nil {
+			out.q.close()
+		}
+		return err
+	}
+	return nil
+}
+
+// SetOutputOptions sets level limits, filter, redaction, duplicate
+// suppression and write timeout of a named output. Async options are
+// ignored. Returns an error if output is not found or if it could not be
+// locked before its write timeout.
+func (l *Logger) SetOutputOptions(name string, opts OutputOptions) error {
+	l.mu.Lock()
+	out, ok := l.outputs[name]
+	l.mu.Unlock()
+	if !ok {
+		return ErrOutputNotFound.WrapArgs(name)
+	}
+	if !out.lock() {
+		return ErrWriteTimeout.WrapArgs(name)
+	}
+	var summary *Fields
+	if out.dd != nil {
+		summary = out.dd.summary()
+	}
+	opts.Async = out.opts.Async
+	out.opts = opts
+	out.dd = newdedup(opts.Dedup)
+	atomic.StoreInt64(&out.timeout, int64(opts.WriteTimeout))
+	if summary != nil {
+		out.emit(l.ef, nil, summary).wait(l.ef)
+	} else {
+		out.unlock()
+	}
+	return nil
+}
+
+// addOutput registers out under its name which must be unique and not empty.
+func (l *Logger) addOutput(out *output) error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	if name == "" {
+	if out.name == "" {
 		return ErrInvalidName
 	}
-	if _, exists := l.outputs[name]; exists {
-		return ErrDuplicateName.WrapArgs(name)
+	if _, exists := l.outputs[out.name]; exists {
+		return ErrDuplicateName.WrapArgs(out.name)
 	}
-	l.outputs[name] = &output{w, f}
+	l.outputs[out.name] = out
+	l.outlist = append(append([]*output(nil), l.outlist...), out)
 	return nil
 }
 
 // SetLevel sets Logger's LogLevel.
 func (l *Logger) SetLevel(level LogLevel) {
+	atomic.StoreUint32(&l.lvl, uint32(level))
+}
+
+// SetFieldOrder sets the order in which formatters output fields of lines
+// logged by Logger. Default is OrderInsertion.
+func (l *Logger) SetFieldOrder(order FieldOrder) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
-	l.lvl = level
+	l.order = order
+}
+
+// level returns Logger's LogLevel.
+func (l *Logger) level() LogLevel {
+	return LogLevel(atomic.LoadUint32(&l.lvl))
 }
 
 // New returns a new Logger with no defined outputs.
@@ -94,10 +475,10 @@ func New(ef ErrorFunc) *Logger {
 	p := &Logger{
 		mu:      sync.Mutex{},
 		outputs: make(outputmap),
-		lvl:     LevelDebug,
+		lvl:     uint32(LevelDebug),
 		ef:      ef,
 	}
-	p.Log = NewLine(p)
+	p.root = NewLine(p)
 	return p
 }
 
@@ -108,3 +489,173 @@ func NewStd(ef ErrorFunc) *Logger {
 	p.AddOutput("stdout", os.Stdout, NewSimpleFormatter())
 	return p
 }
+
+// Debugf will log a debug message formed from format string and args.
+func (l *Logger) Debugf(format string, args ...interface{}) { l.root.Debugf(format, args...) }
+
+// Debugln will log args as a debug message.
+func (l *Logger) Debugln(args ...interface{}) { l.root.Debugln(args...) }
+
+// Infof will log an info message formed from format string and args.
+func (l *Logger) Infof(format string, args ...interface{}) { l.root.Infof(format, args...) }
+
+// Infoln will log args as an info message.
+func (l *Logger) Infoln(args ...interface{}) { l.root.Infoln(args...) }
+
+// Warningf will log a warning message formed from format string and args.
+func (l *Logger) Warningf(format string, args ...interface{}) { l.root.Warningf(format, args...) }
+
+// Warningln will log args as a warning message.
+func (l *Logger) Warningln(args ...interface{}) { l.root.Warningln(args...) }
+
+// Errorf will log an error and an error message formed from format string and args.
+func (l *Logger) Errorf(err error, format string, args ...interface{}) {
+	l.root.Errorf(err, format, args...)
+}
+
+// Errorln will log an error and args as a warning message.
+func (l *Logger) Errorln(err error, args ...interface{}) { l.root.Errorln(err, args...) }
+
+// Tracef will log a trace message formed from format string and args.
+func (l *Logger) Tracef(format string, args ...interface{}) { l.root.Tracef(format, args...) }
+
+// Traceln will log args as a trace message.
+func (l *Logger) Traceln(args ...interface{}) { l.root.Traceln(args...) }
+
+// Noticef will log a notice message formed from format string and args.
+func (l *Logger) Noticef(format string, args ...interface{}) { l.root.Noticef(format, args...) }
+
+// Noticeln will log args as a notice message.
+func (l *Logger) Noticeln(args ...interface{}) { l.root.Noticeln(args...) }
+
+// Criticalf will log an error and a critical message formed from format string and args.
+func (l *Logger) Criticalf(err error, format string, args ...interface{}) {
+	l.root.Criticalf(err, format, args...)
+}
+
+// Criticalln will log an error and args as a critical message.
+func (l *Logger) Criticalln(err error, args ...interface{}) { l.root.Criticalln(err, args...) }
+
+// Alertf will log an error and an alert message formed from format string and args.
+func (l *Logger) Alertf(err error, format string, args ...interface{}) {
+	l.root.Alertf(err, format, args...)
+}
+
+// Alertln will log an error and args as an alert message.
+func (l *Logger) Alertln(err error, args ...interface{}) { l.root.Alertln(err, args...) }
+
+// Emergencyf will log an error and an emergency message formed from format string and args.
+func (l *Logger) Emergencyf(err error, format string, args ...interface{}) {
+	l.root.Emergencyf(err, format, args...)
+}
+
+// Emergencyln will log an error and args as an emergency message.
+func (l *Logger) Emergencyln(err error, args ...interface{}) { l.root.Emergencyln(err, args...) }
+
+// Fatalf will log an error and a critical message formed from format string
+// and args, flush all outputs and exit the program with status 1.
+func (l *Logger) Fatalf(err error, format string, args ...interface{}) {
+	l.root.Fatalf(err, format, args...)
+}
+
+// Fatalln will log an error and args as a critical message, flush all
+// outputs and exit the program with status 1.
+func (l *Logger) Fatalln(err error, args ...interface{}) { l.root.Fatalln(err, args...) }
+
+// Panicf will log an error and a critical message formed from format string
+// and args, flush all outputs and panic with the message.
+func (l *Logger) Panicf(err error, format string, args ...interface{}) {
+	l.root.Panicf(err, format, args...)
+}
+
+// Panicln will log an error and args as a critical message, flush all
+// outputs and panic with the message.
+func (l *Logger) Panicln(err error, args ...interface{}) { l.root.Panicln(err, args...) }
+
+// Printf will log a message with a custom logging level formed from format string and args.
+func (l *Logger) Printf(level LogLevel, format string, args ...interface{}) {
+	l.root.Printf(level, format, args...)
+}
+
+// Println will log args as a message with custom logging level.
+func (l *Logger) Println(level LogLevel, args ...interface{}) { l.root.Println(level, args...) }
+
+// ToOutputs returns a Log which outputs to specified named outputs.
+func (l *Logger) ToOutputs(names ...string) Log { return l.root.ToOutputs(names...) }
+
+// WithCaller will append the caller file, line and func fields to the next logged line.
+func (l *Logger) WithCaller(skip int) Log { return l.root.withCaller(skip + 1) }
+
+// WithStack will append the stack field to the next logged line.
+func (l *Logger) WithStack(skip, depth int) Log { return l.root.withStack(skip+1, depth) }
+
+// WithFields will append the specified fields to the next logged line.
+func (l *Logger) WithFields(fields *Fields) Log { return l.root.With(fields) }
+
+// With returns a Log that appends the specified fields to every line it logs.
+func (l *Logger) With(fields *Fields) Log { return l.root.With(fields) }
+
+// WithLevel returns a Log that discards lines with a logging level above level.
+func (l *Logger) WithLevel(level LogLevel) Log { return l.root.WithLevel(level) }
+
+// Enabled returns true if a line with the specified level would be logged.
+// It is safe to call from hot paths.
+func (l *Logger) Enabled(level LogLevel) bool { return level <= l.level() }
+
+// V returns a Log that logs normally if level is enabled or a Log that
+// discards everything otherwise. Use it to guard costly argument
+// evaluation, i.e. l.V(LevelDebug).Debugf("%v", expensive()).
+func (l *Logger) V(level LogLevel) Log { return l.root.V(level) }
+
+// DebugfCtx will log a debug message formed from format string and args with fields extracted from ctx.
+func (l *Logger) DebugfCtx(ctx context.Context, format string, args ...interface{}) {
+	l.root.DebugfCtx(ctx, format, args...)
+}
+
+// DebuglnCtx will log args as a debug message with fields extracted from ctx.
+func (l *Logger) DebuglnCtx(ctx context.Context, args ...interface{}) {
+	l.root.DebuglnCtx(ctx, args...)
+}
+
+// InfofCtx will log an info message formed from format string and args with fields extracted from ctx.
+func (l *Logger) InfofCtx(ctx context.Context, format string, args ...interface{}) {
+	l.root.InfofCtx(ctx, format, args...)
+}
+
+// InfolnCtx will log args as an info message with fields extracted from ctx.
+func (l *Logger) InfolnCtx(ctx context.Context, args ...interface{}) {
+	l.root.InfolnCtx(ctx, args...)
+}
+
+// WarningfCtx will log a warning message formed from format string and args with fields extracted from ctx.
+func (l *Logger) WarningfCtx(ctx context.Context, format string, args ...interface{}) {
+	l.root.WarningfCtx(ctx, format, args...)
+}
+
+// WarninglnCtx will log args as a warning message with fields extracted from ctx.
+func (l *Logger) WarninglnCtx(ctx context.Context, args ...interface{}) {
+	l.root.WarninglnCtx(ctx, args...)
+}
+
+// ErrorfCtx will log an error and an error message formed from format string and args with fields extracted from ctx.
+func (l *Logger) ErrorfCtx(ctx context.Context, err error, format string, args ...interface{}) {
+	l.root.ErrorfCtx(ctx, err, format, args...)
+}
+
+// ErrorlnCtx will log an error and args as a warning message with fields extracted from ctx.
+func (l *Logger) ErrorlnCtx(ctx context.Context, err error, args ...interface{}) {
+	l.root.ErrorlnCtx(ctx, err, args...)
+}
+
+// PrintfCtx will log a message with a custom logging level formed from format string and args with fields extracted from ctx.
+func (l *Logger) PrintfCtx(ctx context.Context, level LogLevel, format string, args ...interface{}) {
+	l.root.PrintfCtx(ctx, level, format, args...)
+}
+
+// PrintlnCtx will log args as a message with custom logging level with fields extracted from ctx.
+func (l *Logger) PrintlnCtx(ctx context.Context, level LogLevel, args ...interface{}) {
+	l.root.PrintlnCtx(ctx, level, args...)
+}
+
+// WithContext returns a Log that appends fields extracted from ctx to every line it logs.
+func (l *Logger) WithContext(ctx context.Context) Log { return l.root.WithContext(ctx) }
@@ -5,12 +5,42 @@
 package logex
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 )
 
 // LogLevel is Log logging level.
+//
+// Levels are ordered by severity where a lower value is more severe. The
+// predefined levels between LevelEmergency and LevelDebug match the eight
+// syslog severities and map to syslog and OpenTelemetry severities as
+// follows:
+//
+//	LogLevel        syslog           OpenTelemetry
+//	LevelEmergency  0 emerg          21 FATAL
+//	LevelAlert      1 alert          19 ERROR3
+//	LevelCritical   2 crit           18 ERROR2
+//	LevelError      3 err            17 ERROR
+//	LevelWarning    4 warning        13 WARN
+//	LevelNotice     5 notice         10 INFO2
+//	LevelInfo       6 info            9 INFO
+//	LevelDebug      7 debug           5 DEBUG
+//	LevelTrace      7 debug           1 TRACE
+//	custom levels   7 debug           1 TRACE
+//	LevelPrint      6 info            9 INFO
+//
+// See LevelFromSyslog and LevelFromOTel for the reverse mapping. Custom
+// levels can be named and mapped differently using RegisterLevel.
+//
+// Level values changed when the syslog severities were added; values from
+// LevelError up used to be Error 2, Warning 3, Info 4, Debug 5 and custom
+// levels from 6. Old numeric values decode to different severities, so
+// UnmarshalJSON rejects numbers and UnmarshalText rejects "Custom(N)" with
+// N below LevelCustom.
 type LogLevel byte
 
 const (
@@ -18,14 +48,24 @@ const (
 	LevelNone LogLevel = iota
 	// LevelMute is the silent logging level used to silence the logger.
 	LevelMute
-	// LevelError is the error logging level that prints errors only.
+	// LevelEmergency is the emergency logging level for when the system is unusable.
+	LevelEmergency
+	// LevelAlert is the alert logging level for conditions that must be corrected immediately.
+	LevelAlert
+	// LevelCritical is the critical logging level for critical conditions.
+	LevelCritical
+	// LevelError is the error logging level that prints errors and more severe messages.
 	LevelError
 	// LevelWarning is the warning logging level that prints warnings and errors.
 	LevelWarning
-	// LevelInfo is the info logging level that prints information, warnings and errors.
+	// LevelNotice is the notice logging level for normal but significant conditions.
+	LevelNotice
+	// LevelInfo is the info logging level that prints information, notices, warnings and errors.
 	LevelInfo
 	// LevelDebug is the debug logging level that prints debug messages, information, warnings and errors.
 	LevelDebug
+	// LevelTrace is the trace logging level that prints messages more verbose than debug.
+	LevelTrace
 	// LevelCustom and levels up to LevelPrint are custom logging levels.
 	// To define a custom logging level use: MyLevel := LogLevel(LevelCustom +1).
 	LevelCustom
@@ -40,60 +80,205 @@ func (ll LogLevel) String() string {
 		return "None"
 	case LevelMute:
 		return "Mute"
+	case LevelEmergency:
+		return "Emergency"
+	case LevelAlert:
+		return "Alert"
+	case LevelCritical:
+		return "Critical"
 	case LevelError:
 		return "Error"
 	case LevelWarning:
 		return "Warning"
+	case LevelNotice:
+		return "Notice"
 	case LevelInfo:
 		return "Info"
 	case LevelDebug:
 		return "Debug"
+	case LevelTrace:
+		return "Trace"
 	case LevelPrint:
 		return "Print"
 	default:
+		if info := registeredlevel(ll); info != nil {
+			return info.name
+		}
 		if ll >= LevelCustom && ll < LevelPrint {
-			return fmt.Sprintf("Custom(%d)", byte(ll))
+			return customnames[ll]
 		}
 	}
 	return ""
 }
 
+// customnames are the names of unregistered custom levels.
+var customnames = func() (names [LevelPrint]string) {
+	for ll := LevelCustom; ll < LevelPrint; ll++ {
+		names[ll] = fmt.Sprintf("Custom(%d)", byte(ll))
+	}
+	return
+}()
+
 // MarshalText implements the TextMarshaler interface.
-func (ll *LogLevel) MarshalText() ([]byte, error) {
+// A LogLevel is marshaled as its name, including in JSON.
+func (ll LogLevel) MarshalText() ([]byte, error) {
 	return []byte(ll.String()), nil
 }
 
 // UnmarshalText implements the TextUnmarshaler interface.
+// Names are matched case insensitively and include names of registered
+// levels and syslog severity keywords. "Custom(N)" is accepted only for N
+// from LevelCustom up to but excluding LevelPrint.
 func (ll *LogLevel) UnmarshalText(text []byte) error {
-	switch s := strings.ToLower(string(text)); s {
-	case "none":
-		*ll = LevelNone
-	case "mute":
-		*ll = LevelMute
-	case "error":
-		*ll = LevelError
-	case "warning":
-		*ll = LevelWarning
-	case "info":
-		*ll = LevelInfo
-	case "debug":
-		*ll = LevelDebug
-	case "print":
-		*ll = LevelPrint
-	default:
-		if strings.HasPrefix(s, "custom") {
-			s = strings.TrimPrefix(s, "custom")
-			if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
-				s = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"))
-				lvl, err := strconv.Atoi(s)
-				if err != nil {
-					return ErrUnmarshalLevel.WrapArgs(text)
-				}
-				*ll = LogLevel(lvl)
-				return nil
+	s := strings.ToLower(string(text))
+	if lvl, ok := levelnames[s]; ok {
+		*ll = lvl
+		return nil
+	}
+	levels.mu.RLock()
+	lvl, ok := levels.names[s]
+	levels.mu.RUnlock()
+	if ok {
+		*ll = lvl
+		return nil
+	}
+	if strings.HasPrefix(s, "custom") {
+		s = strings.TrimPrefix(s, "custom")
+		if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+			s = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(s, "("), ")"))
+			lvl, err := strconv.Atoi(s)
+			if err != nil || lvl < int(LevelCustom) || lvl >= int(LevelPrint) {
+				return ErrUnmarshalLevel.WrapArgs(string(text))
 			}
+			*ll = LogLevel(lvl)
+			return nil
 		}
-		return ErrUnmarshalLevel.WrapArgs(string(text))
 	}
+	return ErrUnmarshalLevel.WrapArgs(string(text))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. A LogLevel is
+// unmarshaled from its name using UnmarshalText. Numbers are rejected with
+// ErrUnmarshalLevel as they were written with the old level values.
+func (ll *LogLevel) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return ErrUnmarshalLevel.WrapArgs(string(data))
+	}
+	return ll.UnmarshalText([]byte(s))
+}
+
+// levelnames maps lowercase names of predefined levels to levels.
+var levelnames = map[string]LogLevel{
+	"none":      LevelNone,
+	"mute":      LevelMute,
+	"emergency": LevelEmergency,
+	"emerg":     LevelEmergency,
+	"alert":     LevelAlert,
+	"critical":  LevelCritical,
+	"crit":      LevelCritical,
+	"error":     LevelError,
+	"err":       LevelError,
+	"warning":   LevelWarning,
+	"warn":      LevelWarning,
+	"notice":    LevelNotice,
+	"info":      LevelInfo,
+	"debug":     LevelDebug,
+	"trace":     LevelTrace,
+	"print":     LevelPrint,
+}
+
+// LevelOptions defines options of a level registered with RegisterLevel.
+type LevelOptions struct {
+	// Color is the color ConsoleFormatter and the levelcolor template func
+	// use if the level is not found in a palette. Defaults to ColorNone.
+	Color Color
+	// Severity is a predefined level whose syslog and OpenTelemetry
+	// severities the level maps to, for example LevelNotice. If zero the
+	// level maps like other custom levels, to syslog debug (7) and
+	// OpenTelemetry TRACE (1).
+	Severity LogLevel
+	// OTelSeverity, if not zero, is the OpenTelemetry severity number of
+	// the level, from 1 (TRACE) to 24 (FATAL4), overriding Severity.
+	OTelSeverity int
+}
+
+// levelinfo is a registered level.
+type levelinfo struct {
+	name string
+	opts LevelOptions
+}
+
+// levels is the registry of named custom levels.
+var levels = struct {
+	mu     sync.RWMutex
+	values map[LogLevel]*levelinfo
+	names  map[string]LogLevel
+}{
+	values: make(map[LogLevel]*levelinfo),
+	names:  make(map[string]LogLevel),
+}
+
+// RegisterLevel registers name and options of a custom level value. Name
+// is then used by LogLevel.String and by formatters, and is accepted by
+// LogLevel.UnmarshalText case insensitively.
+//
+// Value must be in the custom level range LevelCustom to LevelPrint-1.
+// Name must not be empty, contain spaces, parentheses or control
+// characters, start with "custom" or equal a predefined level name.
+// opts may be nil. RegisterLevel returns an error if value or name is
+// invalid or was already registered. It is safe for concurrent use.
+func RegisterLevel(value LogLevel, name string, opts *LevelOptions) error {
+	lower := strings.ToLower(name)
+	if value < LevelCustom || value >= LevelPrint || !validlevelname(lower) {
+		return ErrInvalidLevel.WrapArgs(byte(value), name)
+	}
+	if _, ok := levelnames[lower]; ok {
+		return ErrDuplicateLevel.WrapArgs(byte(value), name)
+	}
+	if opts != nil && (opts.OTelSeverity < 0 || opts.OTelSeverity > 24 ||
+		opts.Severity == LevelMute ||
+		opts.Severity >= LevelCustom && opts.Severity != LevelPrint) {
+		return ErrInvalidLevel.WrapArgs(byte(value), name)
+	}
+	info := &levelinfo{name: name}
+	if opts != nil {
+		info.opts = *opts
+	}
+	levels.mu.Lock()
+	defer levels.mu.Unlock()
+	if _, ok := levels.values[value]; ok {
+		return ErrDuplicateLevel.WrapArgs(byte(value), name)
+	}
+	if _, ok := levels.names[lower]; ok {
+		return ErrDuplicateLevel.WrapArgs(byte(value), name)
+	}
+	levels.values[value] = info
+	levels.names[lower] = value
 	return nil
 }
+
+// validlevelname returns if lowercase name is a valid registered level name.
+func validlevelname(name string) bool {
+	if name == "" || strings.HasPrefix(name, "custom") {
+		return false
+	}
+	return strings.IndexFunc(name, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsControl(r) || r == '(' || r == ')'
+	}) < 0
+}
+
+// registeredlevel returns the registered level of value or nil if value is
+// not registered.
+func registeredlevel(value LogLevel) *levelinfo {
+	if value < LevelCustom || value >= LevelPrint {
+		return nil
+	}
+	levels.mu.RLock()
+	info := levels.values[value]
+	levels.mu.RUnlock()
+	return info
+}
@@ -0,0 +1,62 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"context"
+	"fmt"
+)
+
+// noplog is a Log that discards everything. It is returned by V for
+// disabled levels. Fatal and Panic methods still flush log and exit or
+// panic.
+type noplog struct{ log *Logger }
+
+func (noplog) Debugf(string, ...interface{})            {}
+func (noplog) Debugln(...interface{})                   {}
+func (noplog) Infof(string, ...interface{})             {}
+func (noplog) Infoln(...interface{})                    {}
+func (noplog) Warningf(string, ...interface{})          {}
+func (noplog) Warningln(...interface{})                 {}
+func (noplog) Errorf(error, string, ...interface{})     {}
+func (noplog) Errorln(error, ...interface{})            {}
+func (noplog) Tracef(string, ...interface{})            {}
+func (noplog) Traceln(...interface{})                   {}
+func (noplog) Noticef(string, ...interface{})           {}
+func (noplog) Noticeln(...interface{})                  {}
+func (noplog) Criticalf(error, string, ...interface{})  {}
+func (noplog) Criticalln(error, ...interface{})         {}
+func (noplog) Alertf(error, string, ...interface{})     {}
+func (noplog) Alertln(error, ...interface{})            {}
+func (noplog) Emergencyf(error, string, ...interface{}) {}
+func (noplog) Emergencyln(error, ...interface{})        {}
+func (n noplog) Fatalf(error, string, ...interface{})   { n.log.flushall(); exit(1) }
+func (n noplog) Fatalln(error, ...interface{})          { n.log.flushall(); exit(1) }
+func (n noplog) Panicf(_ error, format string, args ...interface{}) {
+	n.log.flushall()
+	panic(fmt.Sprintf(format, args...))
+}
+func (n noplog) Panicln(_ error, args ...interface{})                      { n.log.flushall(); panic(fmt.Sprint(args...)) }
+func (noplog) Printf(LogLevel, string, ...interface{})                     {}
+func (noplog) Println(LogLevel, ...interface{})                            {}
+func (noplog) Enabled(LogLevel) bool                                       { return false }
+func (n noplog) V(LogLevel) Log                                            { return n }
+func (n noplog) ToOutputs(...string) Log                                   { return n }
+func (n noplog) WithCaller(int) Log                                        { return n }
+func (n noplog) WithStack(int, int) Log                                    { return n }
+func (n noplog) WithFields(*Fields) Log                                    { return n }
+func (n noplog) With(*Fields) Log                                          { return n }
+func (n noplog) WithLevel(LogLevel) Log                                    { return n }
+func (n noplog) WithContext(context.Context) Log                           { return n }
+func (noplog) DebugfCtx(context.Context, string, ...interface{})           {}
+func (noplog) DebuglnCtx(context.Context, ...interface{})                  {}
+func (noplog) InfofCtx(context.Context, string, ...interface{})            {}
+func (noplog) InfolnCtx(context.Context, ...interface{})                   {}
+func (noplog) WarningfCtx(context.Context, string, ...interface{})         {}
+func (noplog) WarninglnCtx(context.Context, ...interface{})                {}
+func (noplog) ErrorfCtx(context.Context, error, string, ...interface{})    {}
+func (noplog) ErrorlnCtx(context.Context, error, ...interface{})           {}
+func (noplog) PrintfCtx(context.Context, LogLevel, string, ...interface{}) {}
+func (noplog) PrintlnCtx(context.Context, LogLevel, ...interface{})        {}
@@ -0,0 +1,447 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// otelseverity returns the OpenTelemetry severity number and text of a
+// logging level. Registered levels map to the severity number of their
+// LevelOptions and use their name as text.
+func otelseverity(level LogLevel) (int, string) {
+	if info := registeredlevel(level); info != nil {
+		number := info.opts.OTelSeverity
+		if number == 0 {
+			number, _ = otelseverity(info.opts.Severity)
+		}
+		return number, info.name
+	}
+	switch level {
+	case LevelEmergency:
+		return 21, "FATAL"
+	case LevelAlert:
+		return 19, "ERROR3"
+	case LevelCritical:
+		return 18, "ERROR2"
+	case LevelError:
+		return 17, "ERROR"
+	case LevelWarning:
+		return 13, "WARN"
+	case LevelNotice:
+		return 10, "INFO2"
+	case LevelInfo, LevelPrint:
+		return 9, "INFO"
+	case LevelDebug:
+		return 5, "DEBUG"
+	}
+	return 1, "TRACE"
+}
+
+// LevelFromOTel returns the LogLevel of an OpenTelemetry severity number.
+//
+// Numbers 1-4 (TRACE) map to LevelTrace, 5-8 (DEBUG) to LevelDebug, 9
+// (INFO) to LevelInfo, 10-12 (INFO2-4) to LevelNotice, 13-16 (WARN) to
+// LevelWarning, 17 (ERROR) to LevelError, 18 (ERROR2) to LevelCritical,
+// 19-20 (ERROR3-4) to LevelAlert and 21-24 (FATAL) to LevelEmergency.
+// Unspecified severity (0) maps to LevelNone.
+func LevelFromOTel(number int) LogLevel {
+	switch {
+	case number <= 0:
+		return LevelNone
+	case number <= 4:
+		return LevelTrace
+	case number <= 8:
+		return LevelDebug
+	case number == 9:
+		return LevelInfo
+	case number <= 12:
+		return LevelNotice
+	case number <= 16:
+		return LevelWarning
+	case number == 17:
+		return LevelError
+	case number == 18:
+		return LevelCritical
+	case number <= 20:
+		return LevelAlert
+	}
+	return LevelEmergency
+}
+
+// otlpvalue is an OTLP AnyValue.
+type otlpvalue struct {
+	StringValue *string      `json:"stringValue,omitempty"`
+	BoolValue   *bool        `json:"boolValue,omitempty"`
+	IntValue    *string      `json:"intValue,omitempty"`
+	DoubleValue *float64     `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpvalues  `json:"arrayValue,omitempty"`
+	KvlistValue *otlpkvalues `json:"kvlistValue,omitempty"`
+}
+
+// otlpvalues is an OTLP ArrayValue.
+type otlpvalues struct {
+	Values []otlpvalue `json:"values"`
+}
+
+// otlpkvalues is an OTLP KeyValueList.
+type otlpkvalues struct {
+	Values []otlpkeyvalue `json:"values"`
+}
+
+// otlpkeyvalue is an OTLP KeyValue.
+type otlpkeyvalue struct {
+	Key   string    `json:"key"`
+	Value otlpvalue `json:"value"`
+}
+
+// otlprecord is an OTLP LogRecord.
+type otlprecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber"`
+	SeverityText         string         `json:"severityText"`
+	Body                 otlpvalue      `json:"body"`
+	Attributes           []otlpkeyvalue `json:"attributes,omitempty"`
+	TraceID              string         `json:"traceId,omitempty"`
+	SpanID               string         `json:"spanId,omitempty"`
+	Flags                int            `json:"flags,omitempty"`
+}
+
+// newotlpvalue returns val as an OTLP AnyValue.
+func newotlpvalue(val interface{}) otlpvalue {
+	switch v := val.(type) {
+	case string:
+		return otlpvalue{StringValue: &v}
+	case bool:
+		return otlpvalue{BoolValue: &v}
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		s := fmt.Sprint(v)
+		return otlpvalue{IntValue: &s}
+	case float32:
+		f := float64(v)
+		return otlpvalue{DoubleValue: &f}
+	case float64:
+		return otlpvalue{DoubleValue: &v}
+	case []interface{}:
+		values := &otlpvalues{Values: make([]otlpvalue, 0, len(v))}
+		for _, item := range v {
+			values.Values = append(values.Values, newotlpvalue(item))
+		}
+		return otlpvalue{ArrayValue: values}
+	case map[string]interface{}:
+		return otlpvalue{KvlistValue: &otlpkvalues{Values: newotlpattrs(v)}}
+	case *Fields:
+		values := &otlpkvalues{Values: make([]otlpkeyvalue, 0, v.Len())}
+		v.Walk(func(key FieldKey, val interface{}) bool {
+			values.Values = append(values.Values, otlpkeyvalue{string(key), newotlpvalue(val)})
+			return true
+		})
+		return otlpvalue{KvlistValue: values}
+	}
+	s := logfmtvalue(val)
+	return otlpvalue{StringValue: &s}
+}
+
+// newotlpattrs returns m as OTLP attributes sorted by key.
+func newotlpattrs(m map[string]interface{}) []otlpkeyvalue {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]otlpkeyvalue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, otlpkeyvalue{key, newotlpvalue(m[key])})
+	}
+	return attrs
+}
+
+// OTLPFormatter formats Fields as an OTLP/JSON LogRecord to be written to
+// an OTLPExporter.
+//
+// Logging level is mapped to severity number and text as documented on
+// LogLevel.
+//
+// Custom fields are written as attributes except KeyTraceID, KeySpanID and
+// KeyTraceFlags which set the record trace context. Error is written as
+// "exception.message" and "exception.type" and caller as "code.filepath",
+// "code.lineno" and "code.function" attributes.
+type OTLPFormatter struct{}
+
+// NewOTLPFormatter returns a new OTLPFormatter.
+func NewOTLPFormatter() Formatter { return &OTLPFormatter{} }
+
+// Format implements Formatter interface.
+func (of *OTLPFormatter) Format(fields *Fields) string {
+	number, text := otelseverity(fields.LogLevel())
+	msg := fields.Message()
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	rec := &otlprecord{
+		TimeUnixNano:         strconv.FormatInt(fields.Time().UnixNano(), 10),
+		ObservedTimeUnixNano: strconv.FormatInt(time.Now().UnixNano(), 10),
+		SeverityNumber:       number,
+		SeverityText:         text,
+		Body:                 otlpvalue{StringValue: &msg},
+	}
+	fields.Custom().Walk(func(key FieldKey, val interface{}) bool {
+		switch key {
+		case KeyTraceID:
+			rec.TraceID, _ = val.(string)
+		case KeySpanID:
+			rec.SpanID, _ = val.(string)
+		case KeyTraceFlags:
+			if s, ok := val.(string); ok {
+				flags, _ := strconv.ParseUint(s, 16, 8)
+				rec.Flags = int(flags)
+			}
+		default:
+			rec.Attributes = append(rec.Attributes, otlpkeyvalue{string(key), newotlpvalue(val)})
+		}
+		return true
+	})
+	attrs := make(map[string]interface{})
+	if err := fields.Error(); err != nil {
+		attrs["exception.message"] = err.Error()
+		attrs["exception.type"] = fmt.Sprintf("%T", err)
+	}
+	if file := fields.File(); file != "" {
+		attrs["code.filepath"] = file
+		attrs["code.lineno"] = fields.Line()
+	}
+	if fn := fields.Func(); fn != "" {
+		attrs["code.function"] = fn
+	}
+	rec.Attributes = append(rec.Attributes, newotlpattrs(attrs)...)
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err.Error()
+	}
+	return string(buf)
+}
+
+// OTLPOptions defines OTLPExporter options.
+type OTLPOptions struct {
+	// Endpoint is the collector logs URL,
+	// i.e. "http://localhost:4318/v1/logs".
+	Endpoint string
+	// Headers are additional HTTP request headers.
+	Headers map[string]string
+	// Resource are the resource attributes, i.e. "service.name".
+	Resource map[string]interface{}
+	// BatchSize is the maximum number of records sent in one request.
+	// Defaults to 512.
+	BatchSize int
+	// FlushInterval is the maximum time a record waits to be sent.
+	// Defaults to 5 seconds.
+	FlushInterval time.Duration
+	// MaxRetries is the number of times a failed request is retried.
+	// Defaults to 3. Negative value disables retries.
+	MaxRetries int
+	// RetryBackoff is the initial delay between retries which doubles
+	// after each retry. Defaults to 500 milliseconds.
+	RetryBackoff time.Duration
+	// Client is the HTTP client. Defaults to a client with a 10 second
+	// timeout.
+	Client *http.Client
+	// ErrorFunc is an optional func called if a batch could not be sent.
+	ErrorFunc ErrorFunc
+}
+
+// OTLPExporter is an io.WriteCloser that batches OTLP/JSON LogRecords
+// written by an OTLPFormatter and sends them to an OpenTelemetry collector
+// using OTLP/HTTP. Requests failing with a network error or a retryable
+// status are retried with exponential backoff.
+type OTLPExporter struct {
+	mu       sync.Mutex
+	opts     OTLPOptions
+	resource []otlpkeyvalue
+	batch    []json.RawMessage
+	closed   bool
+
+	sendmu sync.Mutex
+	kick   chan struct{}
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewOTLPExporter returns a new OTLPExporter and starts its goroutine.
+func NewOTLPExporter(opts OTLPOptions) *OTLPExporter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 512
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 500 * time.Millisecond
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	oe := &OTLPExporter{
+		opts:     opts,
+		resource: newotlpattrs(opts.Resource),
+		kick:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	oe.wg.Add(1)
+	go oe.run()
+	return oe
+}
+
+// Write implements io.Writer. p must be a single JSON LogRecord.
+func (oe *OTLPExporter) Write(p []byte) (int, error) {
+	rec := bytes.TrimSpace(p)
+	if !json.Valid(rec) {
+		return 0, ErrExport.WrapArgs("invalid log record")
+	}
+	oe.mu.Lock()
+	defer oe.mu.Unlock()
+	if oe.closed {
+		return 0, ErrClosed
+	}
+	oe.batch = append(oe.batch, append(json.RawMessage(nil), rec...))
+	if len(oe.batch) >= oe.opts.BatchSize {
+		select {
+		case oe.kick <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// run sends batches when full or when flush interval elapses until closed.
+func (oe *OTLPExporter) run() {
+	defer oe.wg.Done()
+	ticker := time.NewTicker(oe.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-oe.kick:
+		case <-ticker.C:
+		case <-oe.done:
+			return
+		}
+		if err := oe.Flush(context.Background()); err != nil && oe.opts.ErrorFunc != nil {
+			oe.opts.ErrorFunc(err)
+		}
+	}
+}
+
+// Flush sends all batched records, retrying failed requests, or returns
+// an error if sending fails or ctx is done. Records of a batch that could
+// not be sent are discarded.
+func (oe *OTLPExporter) Flush(ctx context.Context) error {
+	oe.sendmu.Lock()
+	defer oe.sendmu.Unlock()
+	for {
+		oe.mu.Lock()
+		n := len(oe.batch)
+		if n > oe.opts.BatchSize {
+			n = oe.opts.BatchSize
+		}
+		batch := oe.batch[:n:n]
+		oe.batch = oe.batch[n:]
+		oe.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := oe.send(ctx, batch); err != nil {
+			return err
+		}
+	}
+}
+
+// send sends records in a single request retrying on failure.
+func (oe *OTLPExporter) send(ctx context.Context, records []json.RawMessage) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{"attributes": oe.resource},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope":      map[string]interface{}{"name": "github.com/vedranvuk/logex"},
+						"logRecords": records,
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	backoff := oe.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := oe.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= oe.opts.MaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+// post posts body to the collector and returns an error and if the
+// request should be retried.
+func (oe *OTLPExporter) post(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oe.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, val := range oe.opts.Headers {
+		req.Header.Set(key, val)
+	}
+	resp, err := oe.opts.Client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, ErrExport.WrapArgs(err.Error())
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable,
+		resp.StatusCode == http.StatusGatewayTimeout:
+		return true, ErrExport.WrapArgs(resp.Status)
+	}
+	return false, ErrExport.WrapArgs(resp.Status)
+}
+
+// Close sends remaining records and stops the exporter goroutine.
+func (oe *OTLPExporter) Close() error {
+	oe.mu.Lock()
+	if oe.closed {
+		oe.mu.Unlock()
+		return ErrClosed
+	}
+	oe.closed = true
+	oe.mu.Unlock()
+	close(oe.done)
+	oe.wg.Wait()
+	return oe.Flush(context.Background())
+}
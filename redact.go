@@ -0,0 +1,322 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SecretMask is the text printed in place of a Secret.
+const SecretMask = "[SECRET]"
+
+// Secret wraps a value that is never printed. fmt verbs, Stringer, JSON and
+// text marshaling all output SecretMask.
+type Secret struct {
+	v interface{}
+}
+
+// NewSecret returns v wrapped in a Secret.
+func NewSecret(v interface{}) Secret { return Secret{v} }
+
+// Reveal returns the wrapped value.
+func (s Secret) Reveal() interface{} { return s.v }
+
+// String implements fmt.Stringer.
+func (s Secret) String() string { return SecretMask }
+
+// Format implements fmt.Formatter.
+func (s Secret) Format(f fmt.State, verb rune) { io.WriteString(f, SecretMask) }
+
+// MarshalJSON implements json.Marshaler.
+func (s Secret) MarshalJSON() ([]byte, error) { return []byte(`"` + SecretMask + `"`), nil }
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Secret) MarshalText() ([]byte, error) { return []byte(SecretMask), nil }
+
+// RedactMode defines how a RedactRule redacts matched content.
+type RedactMode int
+
+const (
+	// RedactMask replaces matched content with the Redactor mask.
+	RedactMask RedactMode = iota
+	// RedactDrop removes matched fields or content.
+	RedactDrop
+	// RedactHash replaces matched content with a keyed hash of it so that
+	// equal values can be correlated without revealing them.
+	RedactHash
+)
+
+// RedactRule defines a redaction rule. A rule matches custom fields by key
+// if Keys is not empty and content of the message, error and custom field
+// values if Pattern is not nil.
+type RedactRule struct {
+	// Keys are custom field keys matched case insensitively. The last dot
+	// separated segment of a key is matched as well, i.e. "password"
+	// matches "user.password".
+	Keys []string
+	// Pattern is a regular expression matched against the message, error
+	// and custom field values.
+	Pattern *regexp.Regexp
+	// Validate is an optional func that must return true for a Pattern
+	// match to be redacted.
+	Validate func(match string) bool
+	// Mode is the redaction mode.
+	Mode RedactMode
+}
+
+var (
+	// EmailPattern matches email addresses.
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	// CardNumberPattern matches possible payment card numbers.
+	// Use with LuhnValid to avoid false positives.
+	CardNumberPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	// TokenPattern matches bearer tokens, JWTs and common API key formats.
+	TokenPattern = regexp.MustCompile(`(?i:bearer\s+[A-Za-z0-9._~+/-]+=*)` +
+		`|\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*` +
+		`|\b(?:sk|pk|rk)_(?:live|test)_[A-Za-z0-9]{10,}` +
+		`|\bgh[pousr]_[A-Za-z0-9]{30,}` +
+		`|\bAKIA[0-9A-Z]{16}\b`)
+	// IPv4Pattern matches IPv4 addresses.
+	IPv4Pattern = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	// IPv6Pattern matches possible IPv6 addresses.
+	// Use with ValidIP to avoid false positives.
+	IPv6Pattern = regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}`)
+)
+
+// LuhnValid returns true if digits in s pass the Luhn checksum.
+func LuhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// ValidIP returns true if s is a valid IP address.
+func ValidIP(s string) bool { return net.ParseIP(s) != nil }
+
+// DefaultSensitiveKeys are custom field keys redacted by DefaultRedactRules.
+var DefaultSensitiveKeys = []string{
+	"password", "passwd", "pwd", "secret", "token", "access_token",
+	"refresh_token", "api_key", "apikey", "authorization", "cookie",
+	"set-cookie", "private_key",
+}
+
+// DefaultRedactRules returns rules that redact DefaultSensitiveKeys,
+// emails, card numbers, tokens and IP addresses using mode.
+func DefaultRedactRules(mode RedactMode) []RedactRule {
+	return []RedactRule{
+		{Keys: DefaultSensitiveKeys, Mode: mode},
+		{Pattern: TokenPattern, Mode: mode},
+		{Pattern: EmailPattern, Mode: mode},
+		{Pattern: CardNumberPattern, Validate: LuhnValid, Mode: mode},
+		{Pattern: IPv4Pattern, Validate: ValidIP, Mode: mode},
+		{Pattern: IPv6Pattern, Validate: ValidIP, Mode: mode},
+	}
+}
+
+// RedactOptions defines Redactor options.
+type RedactOptions struct {
+	// Rules are the redaction rules applied in order.
+	Rules []RedactRule
+	// Allow, if not empty, is the list of top level custom field keys
+	// allowed to be output. Custom fields with other keys are dropped.
+	Allow []string
+	// HashKey is the key used by RedactHash.
+	HashKey []byte
+	// Mask is the text that replaces content redacted by RedactMask.
+	// Defaults to "[REDACTED]".
+	Mask string
+}
+
+// Redactor redacts sensitive content from Fields before they are
+// formatted. It is set on a Logger using SetRedactor or on an output using
+// OutputOptions.Redactor.
+type Redactor struct {
+	opts  RedactOptions
+	keys  map[string]*RedactRule
+	allow map[string]struct{}
+}
+
+// NewRedactor returns a new Redactor.
+func NewRedactor(opts RedactOptions) *Redactor {
+	if opts.Mask == "" {
+		opts.Mask = "[REDACTED]"
+	}
+	r := &Redactor{
+		opts: opts,
+		keys: make(map[string]*RedactRule),
+	}
+	for i := range r.opts.Rules {
+		rule := &r.opts.Rules[i]
+		for _, key := range rule.Keys {
+			if _, exists := r.keys[strings.ToLower(key)]; !exists {
+				r.keys[strings.ToLower(key)] = rule
+			}
+		}
+	}
+	if len(opts.Allow) > 0 {
+		r.allow = make(map[string]struct{})
+		for _, key := range opts.Allow {
+			r.allow[strings.ToLower(key)] = struct{}{}
+		}
+	}
+	return r
+}
+
+// keyrule returns the rule matching key or nil.
+func (r *Redactor) keyrule(key FieldKey) *RedactRule {
+	k := strings.ToLower(string(key))
+	if rule, ok := r.keys[k]; ok {
+		return rule
+	}
+	if i := strings.LastIndexByte(k, '.'); i >= 0 {
+		return r.keys[k[i+1:]]
+	}
+	return nil
+}
+
+// hash returns a keyed hash of s.
+func (r *Redactor) hash(s string) string {
+	mac := hmac.New(sha256.New, r.opts.HashKey)
+	mac.Write([]byte(s))
+	return "hash:" + hex.EncodeToString(mac.Sum(nil)[:12])
+}
+
+// redact returns s redacted according to mode.
+func (r *Redactor) redact(s string, mode RedactMode) string {
+	switch mode {
+	case RedactDrop:
+		return ""
+	case RedactHash:
+		return r.hash(s)
+	}
+	return r.opts.Mask
+}
+
+// redactstring applies pattern rules to s.
+func (r *Redactor) redactstring(s string) string {
+	for i := range r.opts.Rules {
+		rule := &r.opts.Rules[i]
+		if rule.Pattern == nil {
+			continue
+		}
+		s = rule.Pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.Validate != nil && !rule.Validate(match) {
+				return match
+			}
+			return r.redact(match, rule.Mode)
+		})
+	}
+	return s
+}
+
+// redactederror is an error whose message was redacted.
+type redactederror struct{ msg string }
+
+func (re *redactederror) Error() string { return re.msg }
+
+// redactvalue applies pattern rules to the string representation of val
+// and returns the redacted string if it changed or val otherwise. Nested
+// Fields are redacted into copies.
+func (r *Redactor) redactvalue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case nil, bool, float32, float64, time.Time, time.Duration, Secret:
+		return val
+	case *Fields:
+		if v == nil {
+			return val
+		}
+		return r.redactfields(v, false)
+	case []*Fields:
+		if v == nil {
+			return val
+		}
+		result := make([]*Fields, len(v))
+		for i, f := range v {
+			if f != nil {
+				result[i] = r.redactfields(f, false)
+			}
+		}
+		return result
+	case string:
+		return r.redactstring(v)
+	case error:
+		s := v.Error()
+		if rs := r.redactstring(s); rs != s {
+			return &redactederror{rs}
+		}
+		return val
+	}
+	s := fmt.Sprint(val)
+	if rs := r.redactstring(s); rs != s {
+		return rs
+	}
+	return val
+}
+
+// Redact returns a redacted copy of fields. Values of nested Fields and
+// Fields slices are redacted recursively. Allow applies to top level keys
+// only.
+func (r *Redactor) Redact(fields *Fields) *Fields { return r.redactfields(fields, true) }
+
+// redactfields returns a redacted copy of fields. Reserved keys and Allow
+// are only handled if top is true.
+func (r *Redactor) redactfields(fields *Fields, top bool) *Fields {
+	result := NewFields()
+	result.SetOrder(fields.Order())
+	fields.Walk(func(key FieldKey, val interface{}) bool {
+		switch {
+		case !top:
+		case key == KeyMessage || key == KeyError:
+			result.set(key, r.redactvalue(val))
+			return true
+		case keyreserved(key):
+			result.set(key, val)
+			return true
+		}
+		if top && r.allow != nil {
+			if _, ok := r.allow[strings.ToLower(string(key))]; !ok {
+				return true
+			}
+		}
+		if rule := r.keyrule(key); rule != nil {
+			if rule.Mode != RedactDrop {
+				result.set(key, r.redact(fmt.Sprint(val), rule.Mode))
+			}
+			return true
+		}
+		result.set(key, r.redactvalue(val))
+		return true
+	})
+	return result
+}
+
+// SetRedactor sets a Redactor applied to every line kept by sampling
+// before it is passed to outputs. If r is nil redaction is disabled.
+func (l *Logger) SetRedactor(r *Redactor) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.redactor = r
+}
@@ -0,0 +1,368 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RotateInterval defines a time based rotation interval.
+type RotateInterval int
+
+const (
+	// RotateNever disables time based rotation.
+	RotateNever RotateInterval = iota
+	// RotateHourly rotates the file at the start of every hour.
+	RotateHourly
+	// RotateDaily rotates the file at local midnight.
+	RotateDaily
+)
+
+// backupTimeFormat is the timestamp format appended to backup file names.
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFileOptions defines RotatingFile options.
+type RotatingFileOptions struct {
+	// MaxSize is the size in bytes after which the file is rotated.
+	// If 0, file is not rotated by size.
+	MaxSize int64
+	// Interval is the time based rotation interval.
+	Interval RotateInterval
+	// MaxBackups is the maximum number of backups to keep.
+	// If 0, backups are not removed by count.
+	MaxBackups int
+	// MaxAge is the maximum age of a backup to keep.
+	// If 0, backups are not removed by age.
+	MaxAge time.Duration
+	// Compress specifies if backups are gzipped in the background.
+	Compress bool
+	// Perm are the permissions of a newly created file. Defaults to 0644.
+	Perm os.FileMode
+	// ReopenOnSIGHUP reopens the file when the process receives SIGHUP, for
+	// use with external tools like logrotate.
+	ReopenOnSIGHUP bool
+}
+
+// RotatingFile is an io.WriteCloser that writes to a file and rotates it by
+// size, time or both. Rotated files are renamed to backups named as the
+// file with a timestamp inserted before the extension, i.e. for
+// "app.log" "app-20200303T130519.000.log".
+//
+// RotatingFile is safe for concurrent use and is meant to be passed to
+// Logger.AddOutput.
+type RotatingFile struct {
+	mu       sync.Mutex
+	filename string
+	opts     RotatingFileOptions
+	file     *os.File
+	size     int64
+	rotateAt time.Time
+	closed   bool
+
+	mill   chan struct{}
+	millwg sync.WaitGroup
+	sighup chan os.Signal
+	done   chan struct{}
+}
+
+// NewRotatingFile opens or creates filename for appending and returns a new
+// RotatingFile or an error.
+func NewRotatingFile(filename string, opts RotatingFileOptions) (*RotatingFile, error) {
+	if opts.Perm == 0 {
+		opts.Perm = 0644
+	}
+	rf := &RotatingFile{
+		filename: filename,
+		opts:     opts,
+		mill:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	rf.millwg.Add(1)
+	go rf.runmill()
+	if opts.ReopenOnSIGHUP {
+		rf.sighup = make(chan os.Signal, 1)
+		signal.Notify(rf.sighup, syscall.SIGHUP)
+		go rf.runsighup()
+	}
+	return rf, nil
+}
+
+// open opens or creates the file and sets it as the current file on
+// success. Must be called under lock.
+func (rf *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(rf.filename), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(rf.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, rf.opts.Perm)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = info.Size()
+	start := time.Now()
+	if rf.size > 0 {
+		start = info.ModTime()
+	}
+	rf.rotateAt = nextrotation(start, rf.opts.Interval)
+	return nil
+}
+
+// nextrotation returns the time of the first rotation after t.
+func nextrotation(t time.Time, interval RotateInterval) time.Time {
+	switch interval {
+	case RotateHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+	case RotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Time{}
+}
+
+// Write implements io.Writer. It rotates the file before writing p if p
+// would exceed MaxSize or rotation interval elapsed. If rotation fails p is
+// still written to the file and the rotation error is returned.
+func (rf *RotatingFile) Write(p []byte) (n int, err error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return 0, ErrClosed
+	}
+	if rf.file == nil {
+		if err = rf.open(); err != nil {
+			return 0, err
+		}
+	}
+	var rerr error
+	if (rf.opts.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.opts.MaxSize) ||
+		(!rf.rotateAt.IsZero() && !time.Now().Before(rf.rotateAt)) {
+		if rerr = rf.rotate(); rf.file == nil {
+			return 0, rerr
+		}
+	}
+	n, err = rf.file.Write(p)
+	rf.size += int64(n)
+	if err == nil {
+		err = rerr
+	}
+	return
+}
+
+// Rotate closes the file, renames it to a backup and opens a new file. If
+// the file cannot be renamed it is reopened and writes continue to it.
+func (rf *RotatingFile) Rotate() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return ErrClosed
+	}
+	return rf.rotate()
+}
+
+// rotate rotates the file. Must be called under lock. If the file cannot
+// be renamed it is reopened under its name. The file is nil if it cannot
+// be opened.
+func (rf *RotatingFile) rotate() (err error) {
+	if rf.file != nil {
+		err = rf.file.Close()
+		rf.file = nil
+	}
+	if err == nil {
+		t := time.Now()
+		for {
+			if _, err := os.Stat(rf.backupname(t)); err != nil {
+				break
+			}
+			t = t.Add(time.Millisecond)
+		}
+		if err = os.Rename(rf.filename, rf.backupname(t)); os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	if oerr := rf.open(); err == nil {
+		err = oerr
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case rf.mill <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Reopen reopens the file under the same name and closes the previously
+// open file. It is used after the file was moved by an external tool. The
+// previous file is kept open if the file cannot be reopened.
+func (rf *RotatingFile) Reopen() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.closed {
+		return ErrClosed
+	}
+	prev := rf.file
+	if err := rf.open(); err != nil {
+		return err
+	}
+	if prev != nil {
+		return prev.Close()
+	}
+	return nil
+}
+
+// Close closes the file and waits for background compression to finish.
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	if rf.closed {
+		rf.mu.Unlock()
+		return ErrClosed
+	}
+	rf.closed = true
+	if rf.sighup != nil {
+		signal.Stop(rf.sighup)
+	}
+	close(rf.done)
+	var err error
+	if rf.file != nil {
+		err = rf.file.Close()
+	}
+	rf.mu.Unlock()
+	rf.millwg.Wait()
+	return err
+}
+
+// split returns the file name without extension and the extension.
+func (rf *RotatingFile) split() (base, ext string) {
+	ext = filepath.Ext(rf.filename)
+	return strings.TrimSuffix(rf.filename, ext), ext
+}
+
+// backupname returns a backup file name for time t.
+func (rf *RotatingFile) backupname(t time.Time) string {
+	base, ext := rf.split()
+	return base + "-" + t.Format(backupTimeFormat) + ext
+}
+
+// backup is a backup file.
+type backup struct {
+	name string
+	time time.Time
+}
+
+// backups returns existing backups sorted from newest to oldest.
+func (rf *RotatingFile) backups() ([]backup, error) {
+	base, ext := rf.split()
+	matches, err := filepath.Glob(base + "-*" + ext + "*")
+	if err != nil {
+		return nil, err
+	}
+	result := []backup{}
+	for _, name := range matches {
+		ts := strings.TrimPrefix(name, base+"-")
+		ts = strings.TrimSuffix(ts, ".gz")
+		ts = strings.TrimSuffix(ts, ext)
+		t, err := time.ParseInLocation(backupTimeFormat, ts, time.Local)
+		if err != nil {
+			continue
+		}
+		result = append(result, backup{name, t})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].time.After(result[j].time) })
+	return result, nil
+}
+
+// runmill compresses and removes backups after each rotation until the
+// file is closed.
+func (rf *RotatingFile) runmill() {
+	defer rf.millwg.Done()
+	for {
+		select {
+		case <-rf.mill:
+			rf.millbackups()
+		case <-rf.done:
+			select {
+			case <-rf.mill:
+				rf.millbackups()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// millbackups removes backups exceeding MaxBackups or MaxAge and compresses
+// remaining uncompressed backups if Compress is set.
+func (rf *RotatingFile) millbackups() {
+	backups, err := rf.backups()
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-rf.opts.MaxAge)
+	for i, b := range backups {
+		if (rf.opts.MaxBackups > 0 && i >= rf.opts.MaxBackups) ||
+			(rf.opts.MaxAge > 0 && b.time.Before(cutoff)) {
+			os.Remove(b.name)
+			continue
+		}
+		if rf.opts.Compress && !strings.HasSuffix(b.name, ".gz") {
+			compressfile(b.name)
+		}
+	}
+}
+
+// compressfile gzips file name to name.gz and removes name.
+func compressfile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err = io.Copy(gz, in); err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
+
+// runsighup reopens the file on SIGHUP until the file is closed.
+func (rf *RotatingFile) runsighup() {
+	for {
+		select {
+		case <-rf.sighup:
+			rf.Reopen()
+		case <-rf.done:
+			return
+		}
+	}
+}
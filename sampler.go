@@ -0,0 +1,179 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	// KeySampled specifies that field marks a line kept by sampling after
+	// its initial budget was spent.
+	KeySampled FieldKey = "sampled"
+	// KeyDropped specifies that field carries the number of lines with the
+	// same level and message dropped by sampling since the last kept line.
+	KeyDropped FieldKey = "dropped"
+)
+
+// samplershards is the number of sampler shards.
+const samplershards = 32
+
+// SamplingBudget defines how many lines with the same level and message
+// are kept in a sampling window.
+type SamplingBudget struct {
+	// First is the number of lines kept at the start of a window.
+	First int
+	// Thereafter keeps every Thereafter-th line after First lines.
+	// If 0, all lines after First are dropped.
+	Thereafter int
+}
+
+// SamplingOptions defines sampling options.
+type SamplingOptions struct {
+	// Window is the duration of a sampling window. Defaults to one second.
+	Window time.Duration
+	// Default is the budget of levels not found in Levels. Zero Default
+	// disables sampling of such levels.
+	Default SamplingBudget
+	// Levels maps logging levels to budgets.
+	Levels map[LogLevel]SamplingBudget
+}
+
+// samplecounter counts lines with the same level and message.
+type samplecounter struct {
+	level   LogLevel
+	message string
+	start   time.Time
+	count   int
+	dropped int
+}
+
+// samplershard is a sampler shard.
+type samplershard struct {
+	mu       sync.Mutex
+	counters map[uint64]*samplecounter
+	swept    time.Time
+}
+
+// sampler samples lines by level and message.
+type sampler struct {
+	opts   SamplingOptions
+	shards [samplershards]samplershard
+}
+
+// newsampler returns a new sampler.
+func newsampler(opts *SamplingOptions) *sampler {
+	s := &sampler{opts: *opts}
+	if s.opts.Window <= 0 {
+		s.opts.Window = time.Second
+	}
+	for i := range s.shards {
+		s.shards[i].counters = make(map[uint64]*samplecounter)
+	}
+	return s
+}
+
+// budget returns the budget of level.
+func (s *sampler) budget(level LogLevel) SamplingBudget {
+	if b, ok := s.opts.Levels[level]; ok {
+		return b
+	}
+	return s.opts.Default
+}
+
+// sample returns true if line with fields should be kept and sets
+// KeySampled and KeyDropped fields to it if required. Windows are timed
+// by the line time or the current time if the line has none.
+//
+// Counters whose window expired are evicted. For evicted counters that
+// dropped lines since their last kept line a summary line is returned
+// carrying the level, message and KeyDropped count.
+func (s *sampler) sample(fields *Fields) (keep bool, expired []*Fields) {
+	level := fields.LogLevel()
+	budget := s.budget(level)
+	if budget == (SamplingBudget{}) {
+		return true, nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte{byte(level)})
+	h.Write([]byte(fields.Message()))
+	key := h.Sum64()
+	now := fields.Time()
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	shard := &s.shards[key%samplershards]
+	shard.mu.Lock()
+	if now.Sub(shard.swept) >= s.opts.Window {
+		for k, c := range shard.counters {
+			if k == key || now.Sub(c.start) < s.opts.Window {
+				continue
+			}
+			if c.dropped > 0 {
+				expired = append(expired, c.summary(now))
+			}
+			delete(shard.counters, k)
+		}
+		shard.swept = now
+	}
+	c, ok := shard.counters[key]
+	if !ok {
+		c = &samplecounter{level: level, message: fields.Message(), start: now}
+		shard.counters[key] = c
+	}
+	if now.Sub(c.start) >= s.opts.Window {
+		c.start = now
+		c.count = 0
+	}
+	c.count++
+	keep, sampled := true, false
+	if c.count > budget.First {
+		sampled = true
+		keep = budget.Thereafter > 0 && (c.count-budget.First)%budget.Thereafter == 0
+	}
+	dropped := 0
+	if keep {
+		dropped, c.dropped = c.dropped, 0
+	} else {
+		c.dropped++
+	}
+	shard.mu.Unlock()
+
+	if keep {
+		if sampled {
+			fields.Set(KeySampled, true)
+		}
+		if dropped > 0 {
+			fields.Set(KeyDropped, dropped)
+		}
+	}
+	return keep, expired
+}
+
+// summary returns a line reporting lines dropped by c at time t.
+func (c *samplecounter) summary(t time.Time) *Fields {
+	fields := newfields(5)
+	fields.set(KeyTime, t)
+	fields.set(KeyLogLevel, c.level)
+	fields.set(KeyMessage, c.message)
+	fields.set(KeySampled, true)
+	fields.set(KeyDropped, c.dropped)
+	return fields
+}
+
+// SetSampling enables sampling of lines using opts after the level check.
+// If opts is nil sampling is disabled.
+func (l *Logger) SetSampling(opts *SamplingOptions) {
+	var s *sampler
+	if opts != nil {
+		s = newsampler(opts)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sampler = s
+}
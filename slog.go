@@ -0,0 +1,175 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+)
+
+// LevelFromSlog returns the LogLevel of a slog.Level.
+//
+// slog.LevelError+12 and above map to LevelEmergency, slog.LevelError+8
+// and above to LevelAlert, slog.LevelError+4 and above to LevelCritical,
+// slog.LevelError and above to LevelError, slog.LevelWarn and above to
+// LevelWarning, slog.LevelInfo+2 and above to LevelNotice,
+// slog.LevelInfo and above to LevelInfo, slog.LevelDebug and above to
+// LevelDebug and slog.LevelDebug-4 and above to LevelTrace. Levels below
+// slog.LevelDebug-4 map to custom levels starting at LevelCustom for
+// slog.LevelDebug-5.
+func LevelFromSlog(level slog.Level) LogLevel {
+	switch {
+	case level >= slog.LevelError+12:
+		return LevelEmergency
+	case level >= slog.LevelError+8:
+		return LevelAlert
+	case level >= slog.LevelError+4:
+		return LevelCritical
+	case level >= slog.LevelError:
+		return LevelError
+	case level >= slog.LevelWarn:
+		return LevelWarning
+	case level >= slog.LevelInfo+2:
+		return LevelNotice
+	case level >= slog.LevelInfo:
+		return LevelInfo
+	case level >= slog.LevelDebug:
+		return LevelDebug
+	case level >= slog.LevelDebug-4:
+		return LevelTrace
+	}
+	custom := int(slog.LevelDebug-4-level) - 1 + int(LevelCustom)
+	if custom >= int(LevelPrint) {
+		return LevelPrint - 1
+	}
+	return LogLevel(custom)
+}
+
+// SlogHandlerOptions defines options of a slog.Handler returned by
+// NewSlogHandler.
+type SlogHandlerOptions struct {
+	// AddSource sets the caller fields from the record source location.
+	AddSource bool
+}
+
+// slogHandler implements slog.Handler on top of a Logger.
+type slogHandler struct {
+	line   *Line
+	opts   SlogHandlerOptions
+	prefix string
+}
+
+// SlogHandler returns a slog.Handler that logs records using Logger l with
+// default options.
+func SlogHandler(l *Logger) slog.Handler { return NewSlogHandler(l, nil) }
+
+// NewSlogHandler returns a slog.Handler that logs records using Logger l.
+//
+// Record levels are converted using LevelFromSlog. Attributes are set as
+// custom fields. Keys of attributes in groups are prefixed with group names
+// separated by dots. An attribute with key "error" and an error value sets
+// the line error, other attributes with reserved keys are prefixed with an
+// underscore. If opts.AddSource is set the source location of a record is
+// set as KeyFile, KeyLine and KeyFunc fields. Fields extracted from the
+// context by the Logger's context extractors are set on every record and a
+// record with a zero time is logged without a time field.
+func NewSlogHandler(l *Logger, opts *SlogHandlerOptions) slog.Handler {
+	h := &slogHandler{line: l.root}
+	if opts != nil {
+		h.opts = *opts
+	}
+	return h
+}
+
+// Enabled implements slog.Handler.
+func (h *slogHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	lvl := LevelFromSlog(level)
+	return h.line.Enabled(lvl)
+}
+
+// Handle implements slog.Handler.
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	line := h.line.derive()
+	if ctx != nil {
+		for _, ce := range h.line.log.contextextractors() {
+			ce(ctx, line.fields)
+		}
+	}
+	var err error
+	r.Attrs(func(attr slog.Attr) bool {
+		if e := h.addattr(line.fields, h.prefix, attr); e != nil {
+			err = e
+		}
+		return true
+	})
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		line.fields.set(KeyFile, frame.File)
+		line.fields.set(KeyLine, frame.Line)
+		line.fields.set(KeyFunc, frame.Function)
+	}
+	line.emit(r.Time, LevelFromSlog(r.Level), err, r.Message)
+	return nil
+}
+
+// addattr sets attr to fields prefixing its key with prefix. It returns
+// the attribute value if attr is an error attribute.
+func (h *slogHandler) addattr(fields *Fields, prefix string, attr slog.Attr) (err error) {
+	attr.Value = attr.Value.Resolve()
+	if attr.Equal(slog.Attr{}) {
+		return nil
+	}
+	if attr.Value.Kind() == slog.KindGroup {
+		if attr.Key != "" {
+			prefix += attr.Key + "."
+		}
+		for _, a := range attr.Value.Group() {
+			if e := h.addattr(fields, prefix, a); e != nil {
+				err = e
+			}
+		}
+		return
+	}
+	key := FieldKey(prefix + attr.Key)
+	val := attr.Value.Any()
+	if key == KeyError {
+		if e, ok := val.(error); ok {
+			return e
+		}
+	}
+	if keyreserved(key) {
+		key = "_" + key
+	}
+	fields.set(key, val)
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	line := h.line.derive()
+	for _, attr := range attrs {
+		if err := h.addattr(line.fields, h.prefix, attr); err != nil {
+			line.fields.set(KeyError, err)
+		}
+	}
+	return &slogHandler{line: line, opts: h.opts, prefix: h.prefix}
+}
+
+// WithGroup implements slog.Handler.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{
+		line:   h.line,
+		opts:   h.opts,
+		prefix: strings.TrimSuffix(h.prefix+name, ".") + ".",
+	}
+}
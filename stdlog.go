@@ -0,0 +1,151 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StdWriter is an io.Writer that logs each written line to a Logger at a
+// fixed level. It is meant as output of a standard library log.Logger.
+//
+// If created with the prefix and flags of the log.Logger writing to it,
+// StdWriter strips the prefix and parses the timestamp and caller written
+// by the log.Logger into KeyTime, KeyFile and KeyLine fields. Lines that do
+// not parse are logged as is.
+type StdWriter struct {
+	line   *Line
+	level  LogLevel
+	prefix string
+	flags  int
+}
+
+// NewStdWriter returns a new StdWriter that logs to l at level and parses
+// lines written by a log.Logger with specified prefix and flags.
+func NewStdWriter(l *Logger, level LogLevel, prefix string, flags int) *StdWriter {
+	return &StdWriter{
+		line:   l.root,
+		level:  level,
+		prefix: prefix,
+		flags:  flags,
+	}
+}
+
+// Writer returns an io.Writer that logs each written line at level.
+func (l *Logger) Writer(level LogLevel) io.Writer {
+	return NewStdWriter(l, level, "", 0)
+}
+
+// StdLogger returns a standard library log.Logger that logs to l at level.
+func (l *Logger) StdLogger(level LogLevel) *log.Logger {
+	return log.New(l.Writer(level), "", 0)
+}
+
+// RedirectStdLog redirects output of the standard library log package to
+// l at level. Prefix and flags currently set on the log package are parsed
+// from its lines. It returns a func that restores the previous output.
+func RedirectStdLog(l *Logger, level LogLevel) (restore func()) {
+	prev := log.Writer()
+	log.SetOutput(NewStdWriter(l, level, log.Prefix(), log.Flags()))
+	return func() { log.SetOutput(prev) }
+}
+
+// Write implements io.Writer.
+func (sw *StdWriter) Write(p []byte) (int, error) {
+	for _, s := range strings.Split(string(p), "\n") {
+		if s == "" {
+			continue
+		}
+		t, file, line, msg := sw.parse(s)
+		l := sw.line
+		if file != "" {
+			l = l.derive()
+			l.fields.set(KeyFile, file)
+			l.fields.set(KeyLine, line)
+		}
+		l.emit(t, sw.level, nil, msg)
+	}
+	return len(p), nil
+}
+
+// parse parses the log.Logger header from s. If s does not parse it
+// returns current time and s as msg.
+func (sw *StdWriter) parse(s string) (t time.Time, file string, line int, msg string) {
+	t = time.Now()
+	msg = s
+	rest := s
+	if sw.prefix != "" && sw.flags&log.Lmsgprefix == 0 {
+		if !strings.HasPrefix(rest, sw.prefix) {
+			return
+		}
+		rest = rest[len(sw.prefix):]
+	}
+	loc := time.Local
+	if sw.flags&log.LUTC != 0 {
+		loc = time.UTC
+	}
+	stamp, layout := "", ""
+	if sw.flags&log.Ldate != 0 {
+		if len(rest) < 11 {
+			return
+		}
+		stamp, layout, rest = rest[:11], "2006/01/02 ", rest[11:]
+	}
+	if sw.flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		n, tl := 9, "15:04:05 "
+		if sw.flags&log.Lmicroseconds != 0 {
+			n, tl = 16, "15:04:05.000000 "
+		}
+		if len(rest) < n {
+			return
+		}
+		stamp, layout, rest = stamp+rest[:n], layout+tl, rest[n:]
+	}
+	if layout != "" {
+		pt, err := time.ParseInLocation(layout, stamp, loc)
+		if err != nil {
+			return
+		}
+		if sw.flags&log.Ldate == 0 {
+			now := time.Now().In(loc)
+			pt = time.Date(now.Year(), now.Month(), now.Day(),
+				pt.Hour(), pt.Minute(), pt.Second(), pt.Nanosecond(), loc)
+		}
+		t = pt
+	}
+	if sw.flags&(log.Lshortfile|log.Llongfile) != 0 {
+		var ok bool
+		if file, line, rest, ok = parsecaller(rest); !ok {
+			return time.Now(), "", 0, s
+		}
+	}
+	if sw.prefix != "" && sw.flags&log.Lmsgprefix != 0 {
+		rest = strings.TrimPrefix(rest, sw.prefix)
+	}
+	msg = rest
+	return
+}
+
+// parsecaller parses a "file:line: " caller from the start of s.
+func parsecaller(s string) (file string, line int, rest string, ok bool) {
+	for i := 0; i < len(s); {
+		idx := strings.Index(s[i:], ": ")
+		if idx < 0 {
+			return
+		}
+		idx += i
+		if colon := strings.LastIndexByte(s[:idx], ':'); colon > 0 {
+			if n, err := strconv.Atoi(s[colon+1 : idx]); err == nil {
+				return s[:colon], n, s[idx+2:], true
+			}
+		}
+		i = idx + 2
+	}
+	return
+}
@@ -0,0 +1,400 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyslogFacility is a syslog facility.
+type SyslogFacility int
+
+const (
+	// FacilityKern is the kernel messages facility.
+	FacilityKern SyslogFacility = iota
+	// FacilityUser is the user-level messages facility.
+	FacilityUser
+	// FacilityMail is the mail system facility.
+	FacilityMail
+	// FacilityDaemon is the system daemons facility.
+	FacilityDaemon
+	// FacilityAuth is the security/authorization messages facility.
+	FacilityAuth
+	// FacilitySyslog is the facility of messages generated by syslogd.
+	FacilitySyslog
+	// FacilityLpr is the line printer subsystem facility.
+	FacilityLpr
+	// FacilityNews is the network news subsystem facility.
+	FacilityNews
+	// FacilityUucp is the UUCP subsystem facility.
+	FacilityUucp
+	// FacilityCron is the clock daemon facility.
+	FacilityCron
+	// FacilityAuthPriv is the private security/authorization messages facility.
+	FacilityAuthPriv
+	// FacilityFtp is the FTP daemon facility.
+	FacilityFtp
+)
+
+const (
+	// FacilityLocal0 is the local use 0 facility.
+	FacilityLocal0 SyslogFacility = iota + 16
+	// FacilityLocal1 is the local use 1 facility.
+	FacilityLocal1
+	// FacilityLocal2 is the local use 2 facility.
+	FacilityLocal2
+	// FacilityLocal3 is the local use 3 facility.
+	FacilityLocal3
+	// FacilityLocal4 is the local use 4 facility.
+	FacilityLocal4
+	// FacilityLocal5 is the local use 5 facility.
+	FacilityLocal5
+	// FacilityLocal6 is the local use 6 facility.
+	FacilityLocal6
+	// FacilityLocal7 is the local use 7 facility.
+	FacilityLocal7
+)
+
+// SyslogFormat is a syslog message format.
+type SyslogFormat int
+
+const (
+	// SyslogRFC5424 is the RFC 5424 message format.
+	SyslogRFC5424 SyslogFormat = iota
+	// SyslogRFC3164 is the legacy BSD RFC 3164 message format.
+	SyslogRFC3164
+)
+
+// DefaultStructuredDataID is the SD-ID of the structured data element that
+// carries custom fields in RFC 5424 messages. 32473 is the enterprise
+// number reserved for documentation use.
+const DefaultStructuredDataID = "logex@32473"
+
+// syslogseverity returns the syslog severity of a logging level.
+// Registered levels map to the severity of their LevelOptions.Severity.
+func syslogseverity(level LogLevel) int {
+	if info := registeredlevel(level); info != nil && info.opts.Severity != LevelNone {
+		level = info.opts.Severity
+	}
+	switch level {
+	case LevelEmergency:
+		return 0
+	case LevelAlert:
+		return 1
+	case LevelCritical:
+		return 2
+	case LevelError:
+		return 3
+	case LevelWarning:
+		return 4
+	case LevelNotice:
+		return 5
+	case LevelInfo, LevelPrint:
+		return 6
+	}
+	return 7
+}
+
+// LevelFromSyslog returns the LogLevel of a syslog severity.
+// Severities above debug (7) map to LevelDebug.
+func LevelFromSyslog(severity int) LogLevel {
+	switch {
+	case severity <= 0:
+		return LevelEmergency
+	case severity >= 7:
+		return LevelDebug
+	}
+	return LevelEmergency + LogLevel(severity)
+}
+
+// SyslogOptions defines SyslogFormatter options.
+type SyslogOptions struct {
+	// Format is the message format.
+	Format SyslogFormat
+	// Facility is the message facility. As processes may not log using
+	// FacilityKern, FacilityUser is used instead.
+	Facility SyslogFacility
+	// Hostname is the message hostname. Defaults to os.Hostname().
+	Hostname string
+	// AppName is the message app-name or tag. Defaults to executable name.
+	AppName string
+	// ProcID is the message procid. Defaults to process id.
+	ProcID string
+	// MsgID is the RFC 5424 message msgid. Optional.
+	MsgID string
+	// StructuredDataID is the RFC 5424 SD-ID of the element carrying custom
+	// fields. Defaults to DefaultStructuredDataID.
+	StructuredDataID string
+}
+
+// SyslogFormatter formats Fields as syslog messages.
+//
+// Logging level is mapped to severity as documented on LogLevel.
+//
+// In RFC 5424 format custom fields, error and caller are written as
+// structured data parameters. In RFC 3164 format they are appended to the
+// message as logfmt pairs.
+type SyslogFormatter struct {
+	opts SyslogOptions
+}
+
+// NewSyslogFormatter returns a new SyslogFormatter.
+func NewSyslogFormatter(opts SyslogOptions) Formatter {
+	if opts.Hostname == "" {
+		opts.Hostname, _ = os.Hostname()
+	}
+	if opts.AppName == "" && len(os.Args) > 0 {
+		opts.AppName = filepath.Base(os.Args[0])
+	}
+	if opts.ProcID == "" {
+		opts.ProcID = strconv.Itoa(os.Getpid())
+	}
+	if opts.Facility == FacilityKern {
+		opts.Facility = FacilityUser
+	}
+	if opts.StructuredDataID == "" {
+		opts.StructuredDataID = DefaultStructuredDataID
+	}
+	return &SyslogFormatter{opts}
+}
+
+// Format implements Formatter interface.
+func (sf *SyslogFormatter) Format(fields *Fields) string {
+	pri := int(sf.opts.Facility)*8 + syslogseverity(fields.LogLevel())
+	sb := &strings.Builder{}
+	sb.WriteString("<" + strconv.Itoa(pri) + ">")
+	if sf.opts.Format == SyslogRFC3164 {
+		sf.format3164(sb, fields)
+	} else {
+		sf.format5424(sb, fields)
+	}
+	return sb.String()
+}
+
+// syslogparams returns error, caller and custom fields as key/value pairs
+// in walk order with error and caller first.
+func syslogparams(fields *Fields) (keys, values []string) {
+	if err := fields.Error(); err != nil {
+		keys = append(keys, "error")
+		values = append(values, err.Error())
+	}
+	if file := fields.File(); file != "" {
+		keys = append(keys, "caller")
+		values = append(values, file+":"+strconv.Itoa(fields.Line()))
+	}
+	fields.Custom().Walk(func(key FieldKey, val interface{}) bool {
+		keys = append(keys, string(key))
+		values = append(values, logfmtvalue(val))
+		return true
+	})
+	return
+}
+
+// format5424 writes an RFC 5424 message without PRI to sb.
+func (sf *SyslogFormatter) format5424(sb *strings.Builder, fields *Fields) {
+	sb.WriteString("1 ")
+	sb.WriteString(fields.Time().Format("2006-01-02T15:04:05.000000Z07:00"))
+	sb.WriteByte(' ')
+	sb.WriteString(syslogheader(sf.opts.Hostname, 255))
+	sb.WriteByte(' ')
+	sb.WriteString(syslogheader(sf.opts.AppName, 48))
+	sb.WriteByte(' ')
+	sb.WriteString(syslogheader(sf.opts.ProcID, 128))
+	sb.WriteByte(' ')
+	sb.WriteString(syslogheader(sf.opts.MsgID, 32))
+	sb.WriteByte(' ')
+	keys, values := syslogparams(fields)
+	if len(keys) == 0 {
+		sb.WriteByte('-')
+	} else {
+		sb.WriteByte('[')
+		sb.WriteString(sf.opts.StructuredDataID)
+		for i, key := range keys {
+			sb.WriteByte(' ')
+			sb.WriteString(sdname(key))
+			sb.WriteString(`="`)
+			sb.WriteString(sdescaper.Replace(values[i]))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte(']')
+	}
+	if msg := strings.TrimSuffix(fields.Message(), "\n"); msg != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(msg)
+	}
+}
+
+// format3164 writes an RFC 3164 message without PRI to sb.
+func (sf *SyslogFormatter) format3164(sb *strings.Builder, fields *Fields) {
+	sb.WriteString(fields.Time().Format(time.Stamp))
+	sb.WriteByte(' ')
+	sb.WriteString(syslogheader(sf.opts.Hostname, 255))
+	sb.WriteByte(' ')
+	sb.WriteString(sf.opts.AppName)
+	if sf.opts.ProcID != "" {
+		sb.WriteString("[" + sf.opts.ProcID + "]")
+	}
+	sb.WriteString(": ")
+	sb.WriteString(strings.TrimSuffix(fields.Message(), "\n"))
+	keys, values := syslogparams(fields)
+	for i, key := range keys {
+		sb.WriteByte(' ')
+		sb.WriteString(logfmtkey(key))
+		sb.WriteByte('=')
+		if logfmtquote(values[i]) {
+			sb.WriteString(strconv.Quote(values[i]))
+		} else {
+			sb.WriteString(values[i])
+		}
+	}
+}
+
+// syslogheader returns s as an RFC 5424 header field of at most max
+// printable ASCII characters or NILVALUE if s is empty.
+func syslogheader(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return s
+}
+
+// sdname returns key as a valid RFC 5424 SD-NAME.
+func sdname(key string) string {
+	s := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+	if s == "" {
+		return "_"
+	}
+	if len(s) > 32 {
+		s = s[:32]
+	}
+	return s
+}
+
+// sdescaper escapes RFC 5424 PARAM-VALUE characters.
+var sdescaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// syslogsockets are the local syslog socket paths tried in order.
+var syslogsockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogWriter is an io.WriteCloser that writes each Write as a single
+// syslog message to a local or remote syslog server. On "tcp" networks
+// messages are framed using octet counting, on "unix" stream sockets they
+// are terminated by a newline and on datagram networks they are sent
+// unframed. Failed writes are retried once on a new connection.
+type SyslogWriter struct {
+	mu      sync.Mutex
+	network string
+	address string
+	conn    net.Conn
+	// connnet is the network of conn.
+	connnet string
+	closed  bool
+}
+
+// DialSyslog connects to a syslog server on network at address and returns
+// a new SyslogWriter or an error. Network is one of "unix", "unixgram",
+// "udp" or "tcp" variants. If network is empty the local syslog socket is
+// used.
+func DialSyslog(network, address string) (*SyslogWriter, error) {
+	sw := &SyslogWriter{network: network, address: address}
+	if err := sw.connect(); err != nil {
+		return nil, err
+	}
+	return sw, nil
+}
+
+// connect connects to the syslog server. Must be called under lock.
+func (sw *SyslogWriter) connect() (err error) {
+	if sw.conn != nil {
+		sw.conn.Close()
+		sw.conn = nil
+	}
+	if sw.network != "" {
+		sw.conn, err = net.DialTimeout(sw.network, sw.address, 10*time.Second)
+		sw.connnet = sw.network
+		return
+	}
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range syslogsockets {
+			if sw.conn, err = net.Dial(network, path); err == nil {
+				sw.connnet = network
+				return nil
+			}
+		}
+	}
+	return
+}
+
+// frame returns msg framed for the network of the connection: octet
+// counted on "tcp" networks, newline terminated on other stream networks
+// and unmodified on datagram networks.
+func (sw *SyslogWriter) frame(msg []byte) []byte {
+	switch {
+	case strings.HasPrefix(sw.connnet, "tcp"):
+		return append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	case sw.connnet == "unix":
+		return append(msg[:len(msg):len(msg)], '\n')
+	}
+	return msg
+}
+
+// Write implements io.Writer. A trailing newline is removed from p.
+func (sw *SyslogWriter) Write(p []byte) (n int, err error) {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed {
+		return 0, ErrClosed
+	}
+	msg := p
+	if len(msg) > 0 && msg[len(msg)-1] == '\n' {
+		msg = msg[:len(msg)-1]
+	}
+	if sw.conn != nil {
+		if _, err = sw.conn.Write(sw.frame(msg)); err == nil {
+			return len(p), nil
+		}
+	}
+	if err = sw.connect(); err != nil {
+		return 0, err
+	}
+	if _, err = sw.conn.Write(sw.frame(msg)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close closes the connection to the syslog server.
+func (sw *SyslogWriter) Close() error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.closed {
+		return ErrClosed
+	}
+	sw.closed = true
+	if sw.conn != nil {
+		return sw.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// TemplateData is the data passed to a TemplateFormatter template.
+type TemplateData struct {
+	// Time is the line timestamp.
+	Time time.Time
+	// LogLevel is the line logging level.
+	LogLevel LogLevel
+	// Message is the line message without the trailing newline.
+	Message string
+	// Error is the line error, if any.
+	Error error
+	// File is the caller file, if any.
+	File string
+	// Line is the caller line, if any.
+	Line int
+	// Func is the caller func, if any.
+	Func string
+	// Frames are the stack frames, if any.
+	Frames []*Fields
+	// Fields are the custom fields.
+	Fields map[string]interface{}
+	// Keys are the custom field keys in walk order.
+	Keys []string
+}
+
+// TemplateFormatter formats Fields using a text/template.
+//
+// Besides the text/template builtins the following funcs are available:
+//
+//	time LAYOUT TIME        formats TIME using LAYOUT.
+//	padleft WIDTH VALUE     pads VALUE with spaces on the left to WIDTH.
+//	padright WIDTH VALUE    pads VALUE with spaces on the right to WIDTH.
+//	truncate WIDTH VALUE    truncates VALUE to WIDTH runes.
+//	json VALUE              marshals VALUE to JSON, i.e. quotes a string.
+//	color NAME VALUE        colors VALUE, NAME is one of red, green, yellow,
+//	                        blue, magenta, cyan, gray or dim.
+//	levelcolor LEVEL        prints LEVEL colored using DefaultPalette or the
+//	                        color of a registered level.
+//	upper VALUE             converts VALUE to upper case.
+//	lower VALUE             converts VALUE to lower case.
+//
+// VALUE may be of any type and is converted to string using fmt.Sprint.
+// Colors are only output if Colors is true.
+//
+// Example:
+//
+//	{{.Time | time "2006-01-02 15:04:05"}} [{{.LogLevel | upper | padright 7}}] {{.Message}}
+type TemplateFormatter struct {
+	// Colors enables color funcs.
+	Colors bool
+
+	t *template.Template
+}
+
+// colornames maps color names usable in templates to colors.
+var colornames = map[string]Color{
+	"red":     ColorRed,
+	"green":   ColorGreen,
+	"yellow":  ColorYellow,
+	"blue":    ColorBlue,
+	"magenta": ColorMagenta,
+	"cyan":    ColorCyan,
+	"gray":    ColorGray,
+	"dim":     ColorDim,
+}
+
+// NewTemplateFormatter returns a new TemplateFormatter from template text
+// or an error if text does not parse. A newline is appended to the output
+// if it does not end with one.
+func NewTemplateFormatter(text string) (*TemplateFormatter, error) {
+	tf := &TemplateFormatter{}
+	t, err := template.New("logex").Funcs(tf.funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	tf.t = t
+	return tf, nil
+}
+
+// funcs returns the template funcs.
+func (tf *TemplateFormatter) funcs() template.FuncMap {
+	paint := func(c Color, s string) string {
+		if !tf.Colors || c == ColorNone {
+			return s
+		}
+		return string(c) + s + colorReset
+	}
+	pad := func(width int, v interface{}, left bool) string {
+		s := fmt.Sprint(v)
+		n := width - utf8.RuneCountInString(s)
+		if n <= 0 {
+			return s
+		}
+		if left {
+			return strings.Repeat(" ", n) + s
+		}
+		return s + strings.Repeat(" ", n)
+	}
+	return template.FuncMap{
+		"time": func(layout string, t time.Time) string { return t.Format(layout) },
+		"padleft": func(width int, v interface{}) string {
+			return pad(width, v, true)
+		},
+		"padright": func(width int, v interface{}) string {
+			return pad(width, v, false)
+		},
+		"truncate": func(width int, v interface{}) string {
+			s := fmt.Sprint(v)
+			if utf8.RuneCountInString(s) <= width {
+				return s
+			}
+			return string([]rune(s)[:width])
+		},
+		"json": func(v interface{}) (string, error) {
+			if err, ok := v.(error); ok {
+				v = err.Error()
+			}
+			buf, err := json.Marshal(v)
+			return string(buf), err
+		},
+		"color": func(name string, v interface{}) string {
+			return paint(colornames[name], fmt.Sprint(v))
+		},
+		"levelcolor": func(level LogLevel) string {
+			return paint(levelcolor(level), level.String())
+		},
+		"upper": func(v interface{}) string { return strings.ToUpper(fmt.Sprint(v)) },
+		"lower": func(v interface{}) string { return strings.ToLower(fmt.Sprint(v)) },
+	}
+}
+
+// Format implements Formatter interface.
+func (tf *TemplateFormatter) Format(fields *Fields) string {
+	data := &TemplateData{
+		Time:     fields.Time(),
+		LogLevel: fields.LogLevel(),
+		Message:  strings.TrimSuffix(fields.Message(), "\n"),
+		Error:    fields.Error(),
+		File:     fields.File(),
+		Line:     fields.Line(),
+		Func:     fields.Func(),
+		Frames:   fields.Frames(),
+		Fields:   make(map[string]interface{}),
+	}
+	fields.Custom().Walk(func(key FieldKey, val interface{}) bool {
+		data.Fields[string(key)] = val
+		data.Keys = append(data.Keys, string(key))
+		return true
+	})
+	sb := &strings.Builder{}
+	if err := tf.t.Execute(sb, data); err != nil {
+		return err.Error() + "\n"
+	}
+	if s := sb.String(); !strings.HasSuffix(s, "\n") {
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
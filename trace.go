@@ -0,0 +1,135 @@
+// Copyright 2019 Vedran Vuk. All rights reserved.
+// Use of this source code is governed by a MIT
+// license that can be found in the LICENSE file.
+
+package logex
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// KeyTraceID specifies that field carries a W3C trace id as hex string.
+	KeyTraceID FieldKey = "trace_id"
+	// KeySpanID specifies that field carries a W3C span id as hex string.
+	KeySpanID FieldKey = "span_id"
+	// KeyTraceFlags specifies that field carries W3C trace flags as hex string.
+	KeyTraceFlags FieldKey = "trace_flags"
+)
+
+// TraceparentHeader is the W3C trace context HTTP header name.
+const TraceparentHeader = "traceparent"
+
+// TraceContext identifies a span of a distributed trace.
+type TraceContext struct {
+	// TraceID is the trace id.
+	TraceID [16]byte
+	// SpanID is the span id.
+	SpanID [8]byte
+	// Flags are the trace flags.
+	Flags byte
+}
+
+// Valid returns true if both trace and span id are non-zero.
+func (tc TraceContext) Valid() bool {
+	return tc.TraceID != [16]byte{} && tc.SpanID != [8]byte{}
+}
+
+// Sampled returns true if the sampled flag is set.
+func (tc TraceContext) Sampled() bool { return tc.Flags&1 != 0 }
+
+// String returns tc as a version 00 traceparent header value.
+func (tc TraceContext) String() string {
+	return "00-" + hex.EncodeToString(tc.TraceID[:]) + "-" +
+		hex.EncodeToString(tc.SpanID[:]) + "-" + hex.EncodeToString([]byte{tc.Flags})
+}
+
+// Fields returns tc as KeyTraceID, KeySpanID and KeyTraceFlags fields.
+func (tc TraceContext) Fields() *Fields {
+	f := NewFields()
+	tc.setfields(f)
+	return f
+}
+
+// setfields sets trace fields of tc to fields.
+func (tc TraceContext) setfields(fields *Fields) {
+	fields.Set(KeyTraceID, hex.EncodeToString(tc.TraceID[:]))
+	fields.Set(KeySpanID, hex.EncodeToString(tc.SpanID[:]))
+	fields.Set(KeyTraceFlags, hex.EncodeToString([]byte{tc.Flags}))
+}
+
+// ParseTraceparent parses a W3C traceparent header value or returns an
+// error if it is invalid. Versions above 00 are parsed as 00 as required by
+// the specification.
+func ParseTraceparent(s string) (tc TraceContext, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 ||
+		len(parts[2]) != 16 || len(parts[3]) != 2 ||
+		parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, ErrInvalidTraceparent.WrapArgs(s)
+	}
+	var version, flags [1]byte
+	if !hexdecode(version[:], parts[0]) || !hexdecode(tc.TraceID[:], parts[1]) ||
+		!hexdecode(tc.SpanID[:], parts[2]) || !hexdecode(flags[:], parts[3]) || !tc.Valid() {
+		return TraceContext{}, ErrInvalidTraceparent.WrapArgs(s)
+	}
+	tc.Flags = flags[0]
+	return tc, nil
+}
+
+// hexdecode decodes lower case hex string s to dst and returns false if s
+// is invalid.
+func hexdecode(dst []byte, s string) bool {
+	if strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// tracekey is the context key of a TraceContext.
+type tracekey struct{}
+
+// ContextWithTrace returns a copy of ctx that carries tc.
+func ContextWithTrace(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, tracekey{}, tc)
+}
+
+// TraceFromContext returns the TraceContext carried by ctx and a truth if
+// it exists.
+func TraceFromContext(ctx context.Context) (tc TraceContext, ok bool) {
+	tc, ok = ctx.Value(tracekey{}).(TraceContext)
+	return
+}
+
+// TraceLookupFunc is a prototype of a func that returns the TraceContext
+// of the current span in ctx, i.e. one from an OpenTelemetry span:
+//
+//	func(ctx context.Context) (logex.TraceContext, bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return logex.TraceContext{
+//			TraceID: sc.TraceID(),
+//			SpanID:  sc.SpanID(),
+//			Flags:   byte(sc.TraceFlags()),
+//		}, sc.IsValid()
+//	}
+type TraceLookupFunc func(ctx context.Context) (TraceContext, bool)
+
+// TraceExtractor returns a ContextExtractor that sets trace fields from the
+// first valid TraceContext found by lookups or, if none, from the
+// TraceContext carried by ctx.
+func TraceExtractor(lookups ...TraceLookupFunc) ContextExtractor {
+	return func(ctx context.Context, fields *Fields) {
+		for _, lookup := range lookups {
+			if tc, ok := lookup(ctx); ok && tc.Valid() {
+				tc.setfields(fields)
+				return
+			}
+		}
+		if tc, ok := TraceFromContext(ctx); ok && tc.Valid() {
+			tc.setfields(fields)
+		}
+	}
+}